@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// TestSurfaceFrame checks the frame is orthonormal with the normal, including where the UV coordinates are degenerate.
+func TestSurfaceFrame(t *testing.T) {
+	t.Parallel()
+
+	sphere := newSphere(newVec3(1, 2, 3), 2, 0)
+	for _, d := range []vec3{newVec3(1, 0, 0), newVec3(0, 0, -1), normalize3(newVec3(1, 1, 1)), newVec3(0, 1, 0), newVec3(0, -1, 0)} {
+		pos := add3(newVec3(1, 2, 3), scale3(d, 2))
+		tangent, bitangent := surfaceFrame(sphere, pos, d)
+		if math.Abs(length3(tangent)-1) > 1e-9 || math.Abs(length3(bitangent)-1) > 1e-9 {
+			t.Errorf("Unexpected frame at %v: %v, %v, expected unit vectors.", d, tangent, bitangent)
+		}
+		if math.Abs(dot3(tangent, d)) > 1e-9 || math.Abs(dot3(bitangent, d)) > 1e-9 || math.Abs(dot3(tangent, bitangent)) > 1e-9 {
+			t.Errorf("Unexpected frame at %v: %v, %v, expected perpendicular to each other and to the normal.", d, tangent, bitangent)
+		}
+	}
+}
+
+// TestPerturbNormal checks a flat normal map keeps the normal, and a full red one tilts it 45° toward the tangent.
+func TestPerturbNormal(t *testing.T) {
+	t.Parallel()
+
+	solid := func(c color.RGBA) texture {
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		img.Set(0, 0, c)
+		return texture{Type: "image", File: "n.png", image: img, Scale: newVec2(1, 1), Wrap: "repeat", Filter: "nearest", Strength: 1}
+	}
+	flat := solid(color.RGBA{R: 128, G: 128, B: 255, A: 255})
+	tilted := solid(color.RGBA{R: 255, G: 128, B: 255, A: 255})
+	tilted.File = "t.png"
+	textures := newTextures([]texture{flat, tilted})
+
+	plane := newPlane(newVec3(0, 0, 0), newVec3(0, 1, 0), 0)
+	pos, normal := newVec3(0.3, 0, -0.7), newVec3(0, 1, 0)
+	tangent, _ := surfaceFrame(plane, pos, normal)
+
+	if got := perturbNormal(textures, 0, plane, pos, normal); !approxVec3(got, normal, 0.01) {
+		t.Errorf("Unexpected normal %v with a flat map, expected %v.", got, normal)
+	}
+	got := perturbNormal(textures, 1, plane, pos, normal)
+	if want := normalize3(add3(normal, tangent)); !approxVec3(got, want, 0.01) {
+		t.Errorf("Unexpected normal %v with a tilted map, expected %v.", got, want)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+)
+
+// This file holds the BVH (bounding volume hierarchy) construction, done once at load time.
+// The traversal is in k_rtv1_ray.go, shared by the CPU renderer and the shader.
+
+// bvhLeafSize is the max number of primitives in a leaf.
+// A triangle with normals or a transformed thing takes two things, so it must stay under half bvhMaxLeafThings.
+const bvhLeafSize = bvhMaxLeafThings / 2
+
+// bvhPrimitive is a bounded object of the tree, a single thing, a thing followed by its transform
+// or a triangle followed by its normals.
+type bvhPrimitive struct {
+	things   ThingsT
+	lo, hi   vec3
+	centroid vec3
+}
+
+// thingBounds returns the bounding box of the given thing, computed from the primitive parameters.
+// Returns false for the unbounded ones, like the planes and the infinite cylinders and cones.
+func thingBounds(thing mat4) (lo, hi vec3, ok bool) {
+	switch getThingType(thing) {
+	case SphereType:
+		center, radius, _ := getSphere(thing)
+		r := newVec3(radius, radius, radius)
+		return sub3(center, r), add3(center, r), true
+	case CylinderType:
+		if _, infinite := getCylinderOptions(thing); infinite {
+			return lo, hi, false
+		}
+		center1, center2, radius := getCylinder(thing)
+		e := diskExtent(sub3(center2, center1), radius)
+		lo = sub3(minVec3(center1, center2), e)
+		hi = add3(maxVec3(center1, center2), e)
+		return lo, hi, true
+	case ConeType:
+		if _, infinite := getCylinderOptions(thing); infinite {
+			return lo, hi, false
+		}
+		base, apex, radius, _ := getCone(thing)
+		e := diskExtent(sub3(apex, base), radius)
+		lo = minVec3(sub3(base, e), apex)
+		hi = maxVec3(add3(base, e), apex)
+		return lo, hi, true
+	case TorusType:
+		center, axis, majorRadius, minorRadius := getTorus(thing)
+		e := add3(diskExtent(axis, majorRadius), newVec3(minorRadius, minorRadius, minorRadius))
+		return sub3(center, e), add3(center, e), true
+	case BoxType:
+		center, axisX, axisY, axisZ, halfSize := getBox(thing)
+		e := add3(absVec3(scale3(axisX, halfSize.x)), add3(absVec3(scale3(axisY, halfSize.y)), absVec3(scale3(axisZ, halfSize.z))))
+		return sub3(center, e), add3(center, e), true
+	case QuadType:
+		center, edge1, edge2 := getQuad(thing)
+		e := add3(absVec3(edge1), absVec3(edge2))
+		return sub3(center, e), add3(center, e), true
+	case DiscType:
+		center, normal, radius := getDisc(thing)
+		e := diskExtent(normal, radius)
+		return sub3(center, e), add3(center, e), true
+	case TriangleType:
+		v0, v1, v2, _ := getTriangle(thing)
+		return minVec3(v0, minVec3(v1, v2)), maxVec3(v0, maxVec3(v1, v2)), true
+	default:
+		return lo, hi, false
+	}
+}
+
+// diskExtent returns the half size of the bounding box of a disk of the given radius, perpendicular to axis.
+func diskExtent(axis vec3, radius float) vec3 {
+	d := normalize3(axis)
+	return newVec3(
+		radius*sqrt(max(0, 1-d.x*d.x)),
+		radius*sqrt(max(0, 1-d.y*d.y)),
+		radius*sqrt(max(0, 1-d.z*d.z)),
+	)
+}
+
+func minVec3(a, b vec3) vec3 { return newVec3(min(a.x, b.x), min(a.y, b.y), min(a.z, b.z)) }
+func maxVec3(a, b vec3) vec3 { return newVec3(max(a.x, b.x), max(a.y, b.y), max(a.z, b.z)) }
+func absVec3(v vec3) vec3    { return newVec3(abs(v.x), abs(v.y), abs(v.z)) }
+
+func vec3Component(v vec3, axis int) float {
+	switch axis {
+	case 0:
+		return v.x
+	case 1:
+		return v.y
+	default:
+		return v.z
+	}
+}
+
+// buildBVH reorders the things and builds the tree.
+// The unbounded things are moved first, then the things of each leaf are contiguous, then the CSG children.
+func buildBVH(things ThingsT) (ThingsT, BVHT) {
+	var (
+		unbounded ThingsT
+		prims     []bvhPrimitive
+	)
+	var csgChildren ThingsT
+	for i := 0; i < len(things); i++ {
+		// The CSG children are moved after the tree, so only their CSG gets hit.
+		if getThingType(things[i]) == CSGType {
+			operation, _, slots := getCSG(things[i])
+			children := things[i+1 : i+1+slots]
+			header := newCSG(operation, len(csgChildren), slots)
+			csgChildren = append(csgChildren, children...)
+			if lo, hi, ok := csgBounds(operation, children); ok {
+				prims = append(prims, bvhPrimitive{things: ThingsT{header}, lo: lo, hi: hi, centroid: scale3(add3(lo, hi), 0.5)})
+			} else {
+				unbounded = append(unbounded, header)
+			}
+			i += slots
+			continue
+		}
+
+		lo, hi, ok := thingBounds(things[i])
+		n := 1
+		// Keep the transform right after its thing, and bring the object space bounds to the world space.
+		if transform, transformed := getThingTransform(things, i); transformed {
+			n = 2
+			if ok {
+				lo, hi = transformBounds(transform, lo, hi)
+			}
+		}
+		// Keep the normals right after their triangle.
+		if getThingType(things[i]) == TriangleType && i+1 < len(things) && getThingType(things[i+1]) == TriangleNormalsType {
+			n = 2
+		}
+		if !ok {
+			unbounded = append(unbounded, things[i:i+n]...)
+		} else {
+			prims = append(prims, bvhPrimitive{things: things[i : i+n], lo: lo, hi: hi, centroid: scale3(add3(lo, hi), 0.5)})
+		}
+		i += n - 1
+	}
+
+	out := make(ThingsT, 0, len(things))
+	out = append(out, unbounded...)
+	bvh := BVHT{newBVHHeader(0, 0)}
+	if len(prims) > 0 {
+		out, bvh = buildBVHNode(prims, out, bvh)
+	}
+	bvh[0] = newBVHHeader(len(unbounded), len(bvh))
+
+	// The CSG children go last, the CSG index them from there.
+	for i := range out {
+		if getThingType(out[i]) == CSGType {
+			operation, first, slots := getCSG(out[i])
+			out[i] = newCSG(operation, len(out)+first, slots)
+		}
+	}
+	out = append(out, csgChildren...)
+
+	return out, bvh
+}
+
+// buildBVHNode appends the node holding the given primitives, then its children.
+// The primitives are split at the median of the largest axis of their centroids, which keeps the tree balanced,
+// so the depth stays far under bvhStackSize.
+func buildBVHNode(prims []bvhPrimitive, things ThingsT, bvh BVHT) (ThingsT, BVHT) {
+	lo, hi := prims[0].lo, prims[0].hi
+	cLo, cHi := prims[0].centroid, prims[0].centroid
+	for _, elem := range prims[1:] {
+		lo, hi = minVec3(lo, elem.lo), maxVec3(hi, elem.hi)
+		cLo, cHi = minVec3(cLo, elem.centroid), maxVec3(cHi, elem.centroid)
+	}
+
+	idx := len(bvh)
+	bvh = append(bvh, mat4{}) // Set once the children are known.
+
+	if len(prims) <= bvhLeafSize {
+		first := len(things)
+		for _, elem := range prims {
+			things = append(things, elem.things...)
+		}
+		bvh[idx] = newBVHNode(first, len(things)-first, true, lo, hi)
+		return things, bvh
+	}
+
+	axis := 0
+	size := sub3(cHi, cLo)
+	if size.y > vec3Component(size, axis) {
+		axis = 1
+	}
+	if size.z > vec3Component(size, axis) {
+		axis = 2
+	}
+	slices.SortStableFunc(prims, func(a, b bvhPrimitive) int {
+		return cmp.Compare(vec3Component(a.centroid, axis), vec3Component(b.centroid, axis))
+	})
+	mid := len(prims) / 2
+
+	left := len(bvh)
+	things, bvh = buildBVHNode(prims[:mid], things, bvh)
+	right := len(bvh)
+	things, bvh = buildBVHNode(prims[mid:], things, bvh)
+	bvh[idx] = newBVHNode(left, right, false, lo, hi)
+
+	return things, bvh
+}
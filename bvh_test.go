@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// randomThings returns a mix of all the thing types scattered in a 20 units cube,
+// the triangles with normals taking two things.
+func randomThings(rnd *rand.Rand, n int) ThingsT {
+	point := func() vec3 {
+		return newVec3(rnd.Float64()*20-10, rnd.Float64()*20-10, rnd.Float64()*20-10)
+	}
+	near := func(p vec3) vec3 {
+		return add3(p, newVec3(rnd.Float64()*2-1, rnd.Float64()*2-1, rnd.Float64()*2-1))
+	}
+	things := ThingsT{newPlane(newVec3(0, -12, 0), newVec3(0, 1, 0), 0)}
+	for i := range n {
+		p := point()
+		switch i % 5 {
+		case 0:
+			things = append(things, newSphere(p, 0.1+rnd.Float64(), 0))
+		case 1:
+			things = append(things, newCylinder(p, near(p), 0.1+rnd.Float64()/2, rnd.IntN(2) == 0, false, 0))
+		case 2:
+			things = append(things, newCone(p, near(p), 0.1+rnd.Float64()/2, rnd.IntN(2) == 0, false, 0))
+		case 3:
+			things = append(things, newTriangle(p, near(p), near(p), false, 0))
+		case 4:
+			n := normalize3(near(newVec3(0, 0, 0)))
+			things = append(things, newTriangle(p, near(p), near(p), true, 0), newTriangleNormals(n, n, n))
+		}
+	}
+	return things
+}
+
+// TestBVHIntersection checks the BVH traversal finds the same closest thing as testing all the things.
+func TestBVHIntersection(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // Deterministic test data.
+	things, bvh := buildBVH(randomThings(rnd, 500))
+
+	hits := 0
+	for range 2000 {
+		start := newVec3(rnd.Float64()*30-15, rnd.Float64()*30-15, rnd.Float64()*30-15)
+		dir := normalize3(newVec3(rnd.Float64()*2-1, rnd.Float64()*2-1, rnd.Float64()*2-1))
+
+		// Linear search, the reference.
+		want, wantIdx := 0.0, -1
+		for i, elem := range things {
+			limit := want
+			if limit == 0 {
+				limit = -1
+			}
+			if dist := intersect(start, dir, elem, 0.001, limit); dist != 0 {
+				want, wantIdx = dist, i
+			}
+		}
+
+		_, gotIdx, got := intersection(start, dir, things, bvh, 0.001, -1)
+		if got != want || (want != 0 && gotIdx != wantIdx) {
+			t.Fatalf("Unexpected hit from %v toward %v: thing %d at %g, expected thing %d at %g.", start, dir, gotIdx, got, wantIdx, want)
+		}
+		if want != 0 {
+			hits++
+		}
+	}
+	// Make sure the test exercises the tree.
+	if hits < 100 {
+		t.Fatalf("Unexpected low number of hits: %d.", hits)
+	}
+}
+
+// TestBuildBVH checks each bounded thing is in exactly one leaf whose bounds contain it,
+// the triangle normals staying right after their triangle.
+func TestBuildBVH(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewPCG(3, 4)) //nolint:gosec // Deterministic test data.
+	in := randomThings(rnd, 200)
+	things, bvh := buildBVH(in)
+	if len(things) != len(in) {
+		t.Fatalf("Unexpected number of things: %d, expected %d.", len(things), len(in))
+	}
+
+	unbounded, nodes := getBVHHeader(bvh[0])
+	if unbounded != 1 || getThingType(things[0]) != PlaneType {
+		t.Fatalf("Unexpected unbounded things: %d, expected the plane first.", unbounded)
+	}
+	if nodes != len(bvh) {
+		t.Fatalf("Unexpected number of nodes in the header: %d, expected %d.", nodes, len(bvh))
+	}
+
+	seen := make([]int, len(things))
+	for i := 1; i < len(bvh); i++ {
+		a, b, isLeaf, lo, hi := getBVHNode(bvh[i])
+		if !isLeaf {
+			continue
+		}
+		if b > bvhMaxLeafThings {
+			t.Fatalf("Unexpected leaf %d with %d things, up to %d are supported.", i, b, bvhMaxLeafThings)
+		}
+		for j := a; j < a+b; j++ {
+			seen[j]++
+			if getThingType(things[j]) == TriangleNormalsType {
+				if getThingType(things[j-1]) != TriangleType || j == a {
+					t.Fatalf("Unexpected normals %d, not after their triangle in leaf %d.", j, i)
+				}
+				continue
+			}
+			tLo, tHi, _ := thingBounds(things[j])
+			if minVec3(lo, tLo) != lo || maxVec3(hi, tHi) != hi {
+				t.Fatalf("Unexpected thing %d out of the bounds of its leaf %d.", j, i)
+			}
+		}
+	}
+	for i, n := range seen[unbounded:] {
+		if n != 1 {
+			t.Fatalf("Unexpected thing %d in %d leaves.", unbounded+i, n)
+		}
+	}
+}
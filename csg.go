@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// This file holds the csg object, combining primitives, e.g. a sphere with a hole:
+//
+//	{"type": "csg", "operation": "difference", "objects": [{"type": "sphere", ...}, {"type": "cylinder", ...}]}
+//
+// The intersection logic is in k_rtv1_csg.go.
+
+var csgOperations = map[string]int{
+	"union":        CSGUnion,
+	"intersection": CSGIntersection,
+	"difference":   CSGDifference,
+}
+
+type csg struct {
+	Operation string            `json:"operation"`
+	Objects   []json.RawMessage `json:"objects"`
+
+	operation int
+	children  []sceneObject
+}
+
+func (c *csg) validate(v *sceneValidator, path string) {
+	v.require(path, "operation", "objects")
+	if v.ok(joinPath(path, "operation")) {
+		op, ok := csgOperations[c.Operation]
+		if !ok {
+			v.errorf(joinPath(path, "operation"), "unknown operation %q", c.Operation)
+		}
+		c.operation = op
+	}
+	if v.ok(joinPath(path, "objects")) && (len(c.Objects) < 2 || len(c.Objects) > csgMaxChildren) {
+		v.errorf(joinPath(path, "objects"), "expected 2 to %d objects, got %d", csgMaxChildren, len(c.Objects))
+	}
+
+	for i, elem := range c.Objects {
+		childPath := fmt.Sprintf("%s[%d]", joinPath(path, "objects"), i)
+		child := parseObject(v, childPath, elem)
+		if child == nil {
+			continue
+		}
+		// Only the solids crossed along a single interval can be combined.
+		things := child.things()
+		if len(things) == 0 {
+			continue
+		}
+		_, infinite := getCylinderOptions(things[0])
+		if t := getThingType(things[0]); t != SphereType && t != PlaneType && t != BoxType && t != CylinderType && (t != ConeType || infinite) {
+			v.errorf(childPath, "must be a sphere, a plane, a box, a cylinder or a finite cone")
+			continue
+		}
+		c.children = append(c.children, child)
+	}
+}
+
+// things returns the CSG followed by its children, buildBVH moves the children after the other things.
+func (c csg) things() ThingsT {
+	out := ThingsT{mat4{}}
+	for i, elem := range c.children {
+		things := elem.things()
+		// The finite cylinders and cones are closed solids.
+		if t := getThingType(things[0]); t == CylinderType || t == ConeType {
+			if _, infinite := getCylinderOptions(things[0]); !infinite {
+				things[0][1].w = 1
+			}
+		}
+		if c.operation == CSGDifference && i > 0 {
+			things[0][3].w = 1
+		}
+		out = append(out, things...)
+	}
+	out[0] = newCSG(c.operation, 0, len(out)-1)
+	return out
+}
+
+// csgBounds returns the bounding box of the CSG with the given children.
+// The union needs all of them bounded, the intersection any of them, the difference the first one.
+func csgBounds(operation int, children ThingsT) (lo, hi vec3, ok bool) {
+	first := true
+	for i := 0; i < len(children); i++ {
+		if getThingType(children[i]) == TransformType {
+			continue
+		}
+		cLo, cHi, cOk := thingBounds(children[i])
+		if transform, transformed := getThingTransform(children, i); transformed && cOk {
+			cLo, cHi = transformBounds(transform, cLo, cHi)
+		}
+
+		switch {
+		case operation == CSGUnion && !cOk:
+			return lo, hi, false
+		case operation == CSGUnion && ok:
+			lo, hi = minVec3(lo, cLo), maxVec3(hi, cHi)
+		case operation == CSGIntersection && ok && cOk:
+			lo, hi = maxVec3(lo, cLo), minVec3(hi, cHi)
+		case cOk && !ok && (operation != CSGDifference || first):
+			lo, hi, ok = cLo, cHi, true
+		}
+		first = false
+	}
+	return lo, hi, ok
+}
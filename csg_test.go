@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCSGMember checks the membership of each operation for all the combinations of two children.
+func TestCSGMember(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		a, b                      bool
+		union, intersection, diff bool
+	}{
+		{false, false, false, false, false},
+		{true, false, true, false, true},
+		{false, true, true, false, false},
+		{true, true, true, true, false},
+	} {
+		inside := [csgMaxChildren]bool{tc.a, tc.b}
+		if got := csgMember(CSGUnion, inside, 2); got != tc.union {
+			t.Errorf("Unexpected union membership %t in a: %t, in b: %t.", got, tc.a, tc.b)
+		}
+		if got := csgMember(CSGIntersection, inside, 2); got != tc.intersection {
+			t.Errorf("Unexpected intersection membership %t in a: %t, in b: %t.", got, tc.a, tc.b)
+		}
+		if got := csgMember(CSGDifference, inside, 2); got != tc.diff {
+			t.Errorf("Unexpected difference membership %t in a: %t, in b: %t.", got, tc.a, tc.b)
+		}
+	}
+}
+
+// TestHitCSG checks the ray hits the CSG of two overlapping spheres where the membership changes,
+// from outside and from inside the children.
+func TestHitCSG(t *testing.T) {
+	t.Parallel()
+
+	// Spheres of radius 1 centered on x = -0.5 and x = 0.5, the CSG children after it.
+	csg := func(operation int) ThingsT {
+		return ThingsT{
+			newCSG(operation, 1, 2),
+			newSphere(newVec3(-0.5, 0, 0), 1, 0),
+			newSphere(newVec3(0.5, 0, 0), 1, 0),
+		}
+	}
+	right, left := newVec3(1, 0, 0), newVec3(-1, 0, 0)
+	outside, center := newVec3(-10, 0, 0), newVec3(0, 0, 0)
+
+	for _, tc := range []struct {
+		name      string
+		operation int
+		start     vec3
+		dir       vec3
+		want      float
+		wantIdx   int
+	}{
+		{"union from outside", CSGUnion, outside, right, 8.5, 1},
+		{"union from inside", CSGUnion, center, right, 1.5, 2},
+		{"intersection from outside", CSGIntersection, outside, right, 9.5, 2},
+		{"intersection from inside", CSGIntersection, center, left, 0.5, 2},
+		{"difference from outside", CSGDifference, outside, right, 8.5, 1},
+		{"difference from the removed part", CSGDifference, center, left, 0.5, 2},
+		{"difference through the removed part", CSGDifference, newVec3(10, 0, 0), left, 10.5, 2},
+		{"miss", CSGUnion, newVec3(-10, 5, 0), right, 0, 0},
+	} {
+		got, gotIdx := hitCSG(tc.start, tc.dir, csg(tc.operation), 0, 0.001, -1)
+		if math.Abs(got-tc.want) > 1e-9 || (tc.want != 0 && gotIdx != tc.wantIdx) {
+			t.Errorf("%s: unexpected hit at %g on thing %d, expected %g on thing %d.", tc.name, got, gotIdx, tc.want, tc.wantIdx)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// sourceMaxWidth is the max width and height of the shader source image.
+// It is a texture of the GPU, they are at least 4096 pixels wide.
+const sourceMaxWidth = 4096
+
+// packSceneData stores the things and the BVH in the source image of the shader, below the texture atlas,
+// as float32 bits, one float per texel, and sets their descriptors for the shader, see k_rtv1_data.go.
+func packSceneData(d *sceneData) error {
+	blocks := [][]mat4{d.things, d.bvh}
+	texels := 0
+	for _, elem := range blocks {
+		texels += 16 * len(elem)
+	}
+
+	atlas := d.textures.atlas
+	width := max(1, atlas.Bounds().Dx(), min(sourceMaxWidth, texels))
+	top := atlas.Bounds().Dy()
+	height := max(1, top+(texels+width-1)/width)
+	if height > sourceMaxWidth {
+		return fmt.Errorf("scene too large: %d things and %d BVH nodes don't fit in the source image", len(d.things), len(d.bvh))
+	}
+
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, atlas.Bounds(), atlas, image.Point{}, draw.Src)
+	descriptors := make([]vec4, len(blocks))
+	k := 0
+	for i, elem := range blocks {
+		descriptors[i] = newVec4(float(k), float(top), float(width), float(len(elem)))
+		for _, m := range elem {
+			for _, f := range m.uniform() {
+				off := source.PixOffset(k%width, top+k/width)
+				bits := math.Float32bits(f)
+				source.Pix[off+0] = uint8(bits >> 24)
+				source.Pix[off+1] = uint8(bits >> 16)
+				source.Pix[off+2] = uint8(bits >> 8)
+				source.Pix[off+3] = uint8(bits)
+				k++
+			}
+		}
+	}
+	d.source = source
+	d.thingsBlock, d.bvhBlock = descriptors[0], descriptors[1]
+	return nil
+}
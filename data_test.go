@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// TestPackSceneData decodes the packed things and BVH the way the shader does and checks they round trip.
+func TestPackSceneData(t *testing.T) {
+	t.Parallel()
+
+	values := []float{0, 1, -1, 0.5, -0.1, 3.14159, 1e-20, -1e20, 42, 0.3333, -7, 1024.5, 2, 1e-40}
+	newItems := func(n, offset int) []mat4 {
+		v := func(k int) float { return values[(offset+k)%len(values)] }
+		out := make([]mat4, n)
+		for i := range out {
+			k := 16 * i
+			out[i] = newMat4(newVec4(v(k), v(k+1), v(k+2), v(k+3)), newVec4(v(k+4), v(k+5), v(k+6), v(k+7)),
+				newVec4(v(k+8), v(k+9), v(k+10), v(k+11)), newVec4(v(k+12), v(k+13), v(k+14), v(k+15)))
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name        string
+		things, bvh int
+		atlas       image.Rectangle
+	}{
+		{"empty", 0, 0, image.Rectangle{}},
+		{"few things", 3, 2, image.Rectangle{}},
+		{"many things", 600, 300, image.Rectangle{}},
+		{"below the atlas", 600, 300, image.Rect(0, 0, 2500, 7)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			atlas := image.NewRGBA(tc.atlas)
+			for i := range atlas.Pix {
+				atlas.Pix[i] = uint8(i)
+			}
+			d := sceneData{things: ThingsT(newItems(tc.things, 0)), bvh: BVHT(newItems(tc.bvh, 5)), textures: TexturesT{atlas: atlas}}
+			if err := packSceneData(&d); err != nil {
+				t.Fatalf("Unexpected error: %s.", err)
+			}
+
+			// The atlas stays at the top left, the textures sample the same texels as before the packing.
+			for y := range tc.atlas.Dy() {
+				for x := range tc.atlas.Dx() {
+					if d.source.RGBAAt(x, y) != atlas.RGBAAt(x, y) {
+						t.Fatalf("Unexpected texel %d,%d of the atlas.", x, y)
+					}
+				}
+			}
+			if top := int(d.thingsBlock.y); top != tc.atlas.Dy() {
+				t.Fatalf("Unexpected data at row %d, expected below the atlas at %d.", top, tc.atlas.Dy())
+			}
+
+			// Mirror of getDataMat4 in k_shaderlib_rtv1.kage.
+			decode := func(block vec4, idx int) [16]float {
+				var out [16]float
+				for j := range out {
+					k := int(block.x) + idx*16 + j
+					width := int(block.z)
+					c := d.source.RGBAAt(k%width, int(block.y)+k/width)
+					out[j] = decodeDataFloat(newVec4(float(c.R)/255, float(c.G)/255, float(c.B)/255, float(c.A)/255))
+				}
+				return out
+			}
+			for _, elem := range []struct {
+				block vec4
+				items []mat4
+			}{{d.thingsBlock, d.things}, {d.bvhBlock, d.bvh}} {
+				if int(elem.block.w) != len(elem.items) {
+					t.Fatalf("Unexpected count %g, expected %d.", elem.block.w, len(elem.items))
+				}
+				for i, m := range elem.items {
+					got := decode(elem.block, i)
+					for j, want := range m.uniform() {
+						// The denormals are flushed to 0.
+						if math.Abs(float64(want)) < math.SmallestNonzeroFloat32*(1<<23) {
+							want = 0
+						}
+						if got[j] != float(want) {
+							t.Fatalf("Unexpected float %d of item %d: %g, expected %g.", j, i, got[j], want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
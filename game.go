@@ -1,7 +1,11 @@
+//go:build !headless
+
 package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"image"
 	"image/png"
@@ -9,6 +13,8 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -20,16 +26,32 @@ import (
 type Game struct {
 	time int
 
-	shader shader
+	shader         shader         // Last ready shader, kept when a bigger one fails to compile.
+	shaderJob      *shaderJob     // Pending shader compilation, nil when idle.
+	shaders        *shaderCache   // Shaders compiled during the session.
+	shaderErr      error          // Last shader compilation error, nil once a compilation succeeds.
+	failedCapacity shaderCapacity // Capacity of the compilation which failed with shaderErr.
+	capacity       shaderCapacity // Minimum capacity of the shader, grown when a bigger one compiles.
+	source         *ebiten.Image  // Textures and packed data of the scene, source image of the shader.
+	sourceSrc      *image.RGBA    // What source got created from.
 
 	renderMode RenderMode
 	hideHelp   bool
+	depth      int // Max number of bounces.
 
 	scene    scene
+	scenes   sceneDir
 	sceneIdx int
 
+	sceneErr     error     // Last scene load/compile error, displayed in the HUD.
+	sceneModTime time.Time // Modification time of the scene file when last loaded.
+	sceneCamera  camera    // Camera as defined in the scene file when last loaded.
+
 	renderedImg image.Image
 
+	cpu     tiledRender // Frame of the CPU mode, and of the GPU mode when the shader fails to compile.
+	preview tiledRender // Low resolution frame of the GPU mode, shown while the shader compiles.
+
 	width, height int
 	forceRedraw   bool
 }
@@ -49,38 +71,37 @@ func (g *Game) Update() error {
 		g.renderedImg = nil
 		if g.renderMode == RenderModeGPU {
 			g.renderMode = RenderModeCPU
+			g.preview.cancel()
 		} else {
 			g.renderMode = RenderModeGPU
-			if g.shader.data == nil {
-				g.shader = compileShader(g.scene)
-			}
+			g.cpu.cancel()
+			g.requestShader(g.scene.data)
 		}
+	// Change the max number of bounces.
+	case inpututil.IsKeyJustPressed(ebiten.KeyEqual):
+		g.depth = min(g.depth+1, maxDepth)
+	case inpututil.IsKeyJustPressed(ebiten.KeyMinus):
+		g.depth = max(g.depth-1, 0)
 	// Toggle the help message.
 	case inpututil.IsKeyJustPressed(ebiten.KeyH):
 		g.hideHelp = !g.hideHelp
 	// Cycle through the scenes.
 	case inpututil.IsKeyJustPressed(ebiten.KeyC):
-		scenes, err := sceneFiles.ReadDir("scenes")
-		if err != nil {
-			return fmt.Errorf("failed to read scenes dir: %w", err)
-		}
 		g.sceneIdx++
-
-		if g.sceneIdx >= len(scenes) {
+		if g.sceneIdx >= len(g.scenes.names) {
 			g.sceneIdx = 0
 		}
-
-		g.scene, err = loadScene(scenes[g.sceneIdx].Name())
-		if err != nil {
-			return fmt.Errorf("failed to load scene %s: %w", scenes[g.sceneIdx].Name(), err)
-		}
-		if g.renderMode == RenderModeGPU {
-			g.shader = compileShader(g.scene)
-		}
+		g.loadScene(false)
 
 		g.renderedImg = nil
 	}
 
+	// Reload the scene if the file changed.
+	g.watchScene()
+
+	// Swap in the shader once compiled.
+	g.pollShader()
+
 	const rotationSpeed = 0.3
 	rotated := false
 	if ebiten.IsKeyPressed(ebiten.KeyRight) {
@@ -168,60 +189,203 @@ func (g *Game) Update() error {
 	return nil
 }
 
+// frameKey identifies what a rendered frame shows, a new frame is needed when it changes.
+type frameKey struct {
+	scene         string
+	sceneModTime  time.Time
+	camera        camera
+	depth         int
+	width, height int
+}
+
+// frameKey returns the key of the current frame at the given size.
+func (g *Game) frameKey(width, height int) frameKey {
+	return frameKey{
+		scene:        g.scene.name,
+		sceneModTime: g.sceneModTime,
+		camera:       g.scene.Camera,
+		depth:        g.depth,
+		width:        width,
+		height:       height,
+	}
+}
+
+// tiledRender is a frame rendered from the CPU in the background by tiles,
+// kept once done until the frame changes.
+type tiledRender struct {
+	job    *renderJob
+	key    frameKey      // What job renders.
+	canvas *ebiten.Image // Rendered tiles, kept across frames to display them as they come.
+}
+
+// update starts the render of the given frame unless already started,
+// then copies the tiles rendered since the last call to the canvas and returns it.
+func (r *tiledRender) update(s scene, key frameKey) *ebiten.Image {
+	if r.job == nil || key != r.key {
+		r.cancel()
+		// Keep the previous frame on screen while the new tiles come in, unless resized.
+		if r.canvas == nil || r.canvas.Bounds().Dx() != key.width || r.canvas.Bounds().Dy() != key.height {
+			if r.canvas != nil {
+				r.canvas.Deallocate()
+			}
+			r.canvas = ebiten.NewImage(key.width, key.height)
+		}
+		r.key = key
+		r.job = newRenderer(s, key.width, key.height, key.depth).start(context.Background())
+	}
+
+	for {
+		t, ok := r.job.poll()
+		if !ok {
+			break
+		}
+		r.canvas.SubImage(t.bounds).(*ebiten.Image).WritePixels(tilePixels(r.job.img, t.bounds)) //nolint:forcetypeassert // SubImage always returns an *ebiten.Image.
+	}
+	return r.canvas
+}
+
+// cancel stops the current render, if any. The canvas is kept to be displayed while the next render comes in.
+func (r *tiledRender) cancel() {
+	if r.job == nil {
+		return
+	}
+	r.job.cancel()
+	r.job = nil
+}
+
 // drawCPU draws the scene using the shader code but from the CPU.
 // Used to debug/troubleshoot and verify the shader logic.
+// The frame is rendered in the background by tiles, displayed as they complete.
 func (g *Game) drawCPU(screen *ebiten.Image, width, height int) {
-	// Populate Uniform variables.
-	UniCameraOrigin = g.scene.Camera.Origin
-	UniCameraLookAt = g.scene.Camera.LookAt
+	screen.DrawImage(g.cpu.update(g.scene, g.frameKey(width, height)), nil)
+}
 
-	cx, cy := ebiten.CursorPosition()
-	Cursor = vec2{float(cx), float(cy)}
-	Resolution = vec2{float(width), float(height)}
-	Time = float(g.time) / 60.0
-
-	// Render.
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	buffer := img.Pix
-	for y := range height {
-		for x := range width {
-			c0 := Fragment(newVec4(float(x), float(y), 0, 0), vec2{}, vec4{})
-			off := (y*width + x) * 4
-			buffer[off+0] = uint8(min(255, c0.x*255))
-			buffer[off+1] = uint8(min(255, c0.y*255))
-			buffer[off+2] = uint8(min(255, c0.z*255))
-			buffer[off+3] = uint8(255)
-		}
+// tilePixels returns the pixels of the given part of the image, packed as expected by WritePixels.
+func tilePixels(img *image.RGBA, rect image.Rectangle) []byte {
+	buf := make([]byte, 0, 4*rect.Dx()*rect.Dy())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		off := img.PixOffset(rect.Min.X, y)
+		buf = append(buf, img.Pix[off:off+4*rect.Dx()]...)
 	}
-	screen.WritePixels(buffer)
+	return buf
+}
+
+// previewScale is the downscale factor of the CPU preview shown while the shader compiles.
+const previewScale = 4
+
+// drawPreview draws a low resolution version of the scene from the CPU.
+// Like drawCPU, it is rendered in the background and kept until the frame changes.
+func (g *Game) drawPreview(screen *ebiten.Image, width, height int) {
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(previewScale, previewScale)
+	screen.DrawImage(g.preview.update(g.scene, g.frameKey(max(1, width/previewScale), max(1, height/previewScale))), op)
 }
 
 // drawGPU actually draws the scene using the shader code.
+// Until a shader able to hold the scene is ready, a CPU preview is drawn instead.
+// When the shader fails to compile, the scene is rendered from the CPU in full resolution.
 func (g *Game) drawGPU(screen *ebiten.Image, width, height int) {
-	shader := g.shader.data
-	shaderErr := g.shader.err
-
-	if shaderErr != nil {
-		ebitenutil.DebugPrint(screen, "Error Compiling shader:\n"+shaderErr.Error())
-		return
-	} else if shader == nil {
-		ebitenutil.DebugPrint(screen, "Compiling shader...")
+	if !g.shader.fits(g.scene.data) {
+		if g.shaderJob == nil && g.shaderErr != nil {
+			g.preview.cancel()
+			g.drawCPU(screen, width, height)
+			ebitenutil.DebugPrint(screen, "Error Compiling shader, rendering from the CPU:\n"+g.shaderErr.Error())
+			return
+		}
+		g.cpu.cancel()
+		g.drawPreview(screen, width, height)
+		if g.shaderJob != nil {
+			ebitenutil.DebugPrint(screen, "Compiling shader...")
+		}
 		return
 	}
-	op := &ebiten.DrawRectShaderOptions{}
+	g.preview.cancel()
+	g.cpu.cancel()
+
+	op := &ebiten.DrawTrianglesShaderOptions{}
+	op.Images[0] = g.sourceImage()
 
 	cx, cy := ebiten.CursorPosition()
 
-	op.Uniforms = map[string]any{
-		"Time":       float(g.time) / 60.0,
-		"Resolution": [2]float{float(width), float(height)},
-		"Cursor":     [2]float{float(cx), float(cy)},
+	// The scene itself is passed as uniforms, no need to recompile the shader when it changes.
+	op.Uniforms = g.scene.data.uniforms(g.shader.capacity)
+	op.Uniforms["Time"] = float(g.time) / 60.0
+	op.Uniforms["Resolution"] = [2]float{float(width), float(height)}
+	op.Uniforms["Cursor"] = [2]float{float(cx), float(cy)}
+	op.Uniforms["UniCameraOrigin"] = g.scene.Camera.Origin.uniform()
+	op.Uniforms["UniCameraLookAt"] = g.scene.Camera.LookAt.uniform()
+	op.Uniforms["UniDepth"] = g.depth
+
+	// The source image is not the size of the screen, which rules out DrawRectShader, the screen is drawn as two triangles.
+	w, h := float32(width), float32(height)
+	sw, sh := float32(op.Images[0].Bounds().Dx()), float32(op.Images[0].Bounds().Dy())
+	vertices := []ebiten.Vertex{
+		{DstX: 0, DstY: 0, SrcX: 0, SrcY: 0, ColorR: 1, ColorG: 1, ColorB: 1, ColorA: 1},
+		{DstX: w, DstY: 0, SrcX: sw, SrcY: 0, ColorR: 1, ColorG: 1, ColorB: 1, ColorA: 1},
+		{DstX: 0, DstY: h, SrcX: 0, SrcY: sh, ColorR: 1, ColorG: 1, ColorB: 1, ColorA: 1},
+		{DstX: w, DstY: h, SrcX: sw, SrcY: sh, ColorR: 1, ColorG: 1, ColorB: 1, ColorA: 1},
+	}
+	screen.DrawTrianglesShader(vertices, []uint16{0, 1, 2, 1, 2, 3}, g.shader.data, op)
+}
 
-		"UniCameraOrigin": g.scene.Camera.Origin.uniform(),
-		"UniCameraLookAt": g.scene.Camera.LookAt.uniform(),
+// sourceImage returns the textures and packed data of the current scene as an Ebiten image, created when the scene changes.
+func (g *Game) sourceImage() *ebiten.Image {
+	if src := g.scene.data.source; g.source == nil || g.sourceSrc != src {
+		if g.source != nil {
+			g.source.Deallocate()
+		}
+		g.source, g.sourceSrc = ebiten.NewImageFromImage(src), src
 	}
+	return g.source
+}
 
-	screen.DrawRectShader(width, height, g.shader.data, op)
+// requestShader makes sure a shader able to hold the given scene is ready or being compiled.
+// The current shader is reused if it fits, otherwise a new one is compiled in the background with a grown capacity.
+// A pending compilation which is not needed anymore or too small for the scene gets cancelled.
+func (g *Game) requestShader(d sceneData) {
+	if g.shader.fits(d) {
+		g.cancelShader()
+		return
+	}
+	if g.shaderJob != nil && g.shaderJob.capacity.fits(d) {
+		return
+	}
+	g.cancelShader()
+	// Don't retry a failed compilation, it would fail the same way.
+	grown := g.capacity.grow(d)
+	if g.shaderErr != nil && grown == g.failedCapacity {
+		return
+	}
+	g.shaderJob = compileShaderAsync(g.shaders, grown)
+}
+
+// cancelShader cancels the pending shader compilation, if any.
+func (g *Game) cancelShader() {
+	if g.shaderJob == nil {
+		return
+	}
+	g.shaderJob.cancel()
+	g.shaderJob = nil
+}
+
+// pollShader swaps in the shader once its background compilation is done.
+// On failure, the last ready shader is kept for the scenes it can hold.
+func (g *Game) pollShader() {
+	if g.shaderJob == nil {
+		return
+	}
+	s, ok := g.shaderJob.poll()
+	if !ok {
+		return
+	}
+	g.shaderJob.cancel() // Release the context.
+	g.shaderJob = nil
+	if s.status != shaderStatusReady {
+		g.shaderErr, g.failedCapacity = s.err, s.capacity
+		return
+	}
+	g.shader, g.shaderErr = s, nil
+	g.capacity = s.capacity
 }
 
 // draw the scene.
@@ -265,7 +429,11 @@ func (g *Game) draw(screen *ebiten.Image) image.Image {
 	msg := "\n\n\n"
 	msg += fmt.Sprintf("shader enabled: %t", g.renderMode == RenderModeGPU)
 	if g.renderMode == 0 && g.shader.compileDuration > 0 {
-		msg += fmt.Sprintf(", shader compile time: %s\n", g.shader.compileDuration)
+		msg += fmt.Sprintf(", shader compile time: %s", g.shader.compileDuration)
+		if g.shader.preprocessCached {
+			msg += " (preprocessed source from cache)"
+		}
+		msg += "\n"
 	} else {
 		msg += "\n"
 	}
@@ -274,14 +442,18 @@ func (g *Game) draw(screen *ebiten.Image) image.Image {
 		msg += fmt.Sprintf("png size: %vKB\n", math.Round(float64(buf.Len())/1024.*100.)/100.)
 	}
 	msg += fmt.Sprintf("drawn in: %s\n", duration)
+	if g.cpu.job != nil {
+		msg += fmt.Sprintf("cpu render (%d workers): %s\n", runtime.NumCPU(), g.cpu.job.stats)
+	}
 	msg += fmt.Sprintf("camera origin: %s, lookAt: %s, pitch: %0.2f\n", g.scene.Camera.Origin, g.scene.Camera.LookAt, calculatePitch(g.scene.Camera.Origin, g.scene.Camera.LookAt))
 
-	msg += fmt.Sprintf("w/h: %dx%d\n", width, height)
+	msg += fmt.Sprintf("w/h: %dx%d, depth: %d (up to %d rays per pixel)\n", width, height, g.depth, maxTraceRays)
 
 	msg += "\nControls:\n"
 	msg += " - WASD: move\n"
 	msg += " - QE: up/down\n"
 	msg += " - Arrows: look\n"
+	msg += " - +/-: change depth\n"
 
 	if g.renderMode == 0 {
 		msg += " - Space: Change render mode to CPU\n"
@@ -295,6 +467,12 @@ func (g *Game) draw(screen *ebiten.Image) image.Image {
 	if !g.hideHelp {
 		ebitenutil.DebugPrint(img, msg)
 	}
+
+	// Always show the scene errors, even when the help is hidden.
+	if g.sceneErr != nil {
+		errMsg := "Error loading scene, keeping the last good one:\n" + g.sceneErr.Error()
+		ebitenutil.DebugPrintAt(img, errMsg, 0, height-16*(strings.Count(errMsg, "\n")+1))
+	}
 	return img
 }
 
@@ -338,6 +516,67 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (w, h int) {
 	return outsideWidth, outsideHeight
 }
 
+// runGame opens the window, rendering the scene given on the command line.
+func runGame() {
+	// The scene can be given either as flag or as positional argument.
+	// It can be a scene file or a directory of scene files. The C key cycles through the directory.
+	sceneFlag := flag.String("scene", "", "Scene file or directory to load. Defaults to the embedded scenes.")
+
+	// The lights and materials are passed to the shader as fixed size arrays. The capacity grows
+	// when a scene doesn't fit, which requires to recompile the shader. The objects are not limited.
+	var capacity shaderCapacity
+	flag.IntVar(&capacity.lights, "max-lights", 8, "Initial number of lights the shader can hold.")
+	flag.IntVar(&capacity.materials, "max-materials", 16, "Initial number of materials the shader can hold.")
+	flag.IntVar(&capacity.textures, "max-textures", 4, "Initial number of textures the shader can hold.")
+	diskCache := flag.Bool("shader-cache", true, "Cache the preprocessed shader on disk. No-op in the browser.")
+	depth := flag.Int("depth", maxDepth, fmt.Sprintf("Max number of bounces, up to %d. At most %d rays are traced per pixel, whatever the depth.", maxDepth, maxTraceRays))
+	flag.Parse()
+	if *depth < 0 || *depth > maxDepth {
+		log.Fatalf("Invalid depth %d, expected 0 to %d.", *depth, maxDepth)
+	}
+	scenePath := *sceneFlag
+	if scenePath == "" {
+		scenePath = flag.Arg(0)
+	}
+
+	scenes, sceneIdx, err := openSceneDir(scenePath)
+	if err != nil {
+		log.Fatalf("Failed to open scene %q: %s.", scenePath, err)
+	}
+	s, err := scenes.load(sceneIdx)
+	if err != nil {
+		log.Fatalf("Failed to load scene %s: %s.", scenes.names[sceneIdx], err)
+	}
+
+	g := &Game{
+		scene:       s,
+		scenes:      scenes,
+		sceneIdx:    sceneIdx,
+		sceneCamera: s.Camera,
+		capacity:    capacity,
+		shaders:     newShaderCache(*diskCache),
+		depth:       *depth,
+
+		renderMode: RenderModeGPU,
+	}
+
+	g.sceneModTime = g.currentSceneModTime()
+
+	// TODO: Document the fake shader aspect.
+	// WHen the fake shader mode is enabled, set the render to be CPU.
+	if os.Getenv("FAKE_SHADER") == "1" {
+		g.renderMode = RenderModeCPU
+	}
+
+	// If we are in GPU render mode, compile the shader in the background.
+	// A CPU preview is shown until it is ready.
+	if g.renderMode == RenderModeGPU {
+		g.requestShader(g.scene.data)
+	}
+
+	g.run()
+}
+
 func (g *Game) run() {
 	ebiten.SetWindowTitle("RTv1 - Shader - Go")
 	ebiten.SetWindowSize(initialScreenWidth, initialScreenHeight)
@@ -0,0 +1,22 @@
+//go:build headless
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// This file replaces the Ebiten game in the headless builds.
+// Ebiten initializes its window system when imported, failing without a display,
+// so the builds for CI and servers leave it out and only have the render command.
+//
+// Example:
+//
+//	go build -tags headless -o rtv1 . && ./rtv1 render -scene scenes/a.json -o out.png
+
+// runGame reports the window is not available in the headless builds.
+func runGame() {
+	fmt.Fprintln(os.Stderr, "Built with the headless tag, without window: only the render command is available.")
+	os.Exit(2)
+}
@@ -22,40 +22,48 @@ func getMaterialColor(materials MaterialsT, idx int) vec4 {
 	return color
 }
 
+// maxDepth is the upper bound of the number of bounces.
+// The shader loops need a constant bound, the actual depth is a runtime setting up to this value.
 const maxDepth = 15
 
+// minContribution is the weight under which a bounce doesn't contribute to the final color anymore.
+const minContribution = 1.0 / 256.0
+
 const (
 	SphereType   = 1
 	PlaneType    = 2
 	ConeType     = 3
 	CylinderType = 4
-)
+	TriangleType = 5
 
-// Fragment is the shader's entry point.
-//
-//nolint:revive // Unexported return is required by the shader API.
-func Fragment(position vec4, _ vec2, _ vec4) vec4 {
-	// "Localize" the uniform globals.
-	width, height := int(Resolution.x), int(Resolution.y)
+	// TriangleNormalsType holds the vertex normals of the triangle before it, it is never hit.
+	TriangleNormalsType = 6
 
-	cameraOrigin, cameraLookAt := UniCameraOrigin, UniCameraLookAt
-	// cameraOrigin = newVec3(5*cos(0.5*Time), 0, 5*sin(0.5*Time))
+	// TransformType holds the inverse transform of the thing before it, it is never hit.
+	TransformType = 7
 
-	x := int(position.x)
-	y := int(position.y)
+	// CSGType combines the things it references, see k_rtv1_csg.go.
+	CSGType = 8
 
-	// Inject the scene constructors for the shader mode.
-	// In Go mode, we use the global variables.
-	//scene:things
-	//scene:lights
-	//scene:materials
-	//scene:ambientLight
+	TorusType = 9
+	BoxType   = 10
+	QuadType  = 11
+	DiscType  = 12
+)
+
+// renderPixel computes the color of the given pixel.
+// It is called by the shader's Fragment entry point and by the CPU renderer.
+func renderPixel(x, y int, resolution vec2, cameraOrigin, cameraLookAt vec3, depth int, sceneObjects ThingsT, sceneBVH BVHT, sceneLights LightsT, sceneMaterials MaterialsT, sceneTextures TexturesT, ambientLight mat4) vec4 {
+	width, height := int(resolution.x), int(resolution.y)
 
 	cameraComponents := newCameraComponents(cameraOrigin, cameraLookAt)
 
 	rayDir := initRay(width, height, x, y, cameraComponents)
 
-	out := trace(cameraOrigin, rayDir, sceneLights, sceneObjects, sceneMaterials, ambientLight, maxDepth, x, y)
+	// Same seed for the pixel on the CPU and the GPU.
+	seed := hashSeed(hash(x), y)
+
+	out := trace(cameraOrigin, rayDir, sceneLights, sceneObjects, sceneBVH, sceneMaterials, sceneTextures, ambientLight, depth, seed)
 
 	return out
 }
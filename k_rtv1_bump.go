@@ -0,0 +1,71 @@
+package main
+
+// This file holds the normal maps and the bumps, tilting the normal of the hit point without adding geometry.
+
+// bumpEpsilon is the step of the bump slope estimation, relative to the period of its pattern.
+const bumpEpsilon = 0.01
+
+// getThingTangents returns the directions of increasing u and v at the given point of the thing, in its object space.
+// They are not normalized, nor perpendicular to the normal.
+func getThingTangents(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	if t := getThingType(thing); t == SphereType {
+		return tangentsSphere(thing, pos)
+	} else if t == PlaneType {
+		return tangentsPlane(thing, pos)
+	} else if t == ConeType {
+		return tangentsCone(thing, pos)
+	} else if t == CylinderType {
+		return tangentsCylinder(thing, pos)
+	} else if t == TriangleType {
+		return tangentsTriangle(thing, pos)
+	} else if t == TorusType {
+		return tangentsTorus(thing, pos)
+	} else if t == BoxType {
+		return tangentsBox(thing, pos)
+	} else if t == QuadType {
+		return tangentsQuad(thing, pos)
+	} else if t == DiscType {
+		return tangentsDisc(thing, pos)
+	}
+	return orthonormalBasis(newVec3(0, 1, 0))
+}
+
+// surfaceFrame returns the unit tangent and bitangent perpendicular to the normal, following the UV coordinates of the thing.
+// Where the UV coordinates are degenerate, e.g. at the poles of a sphere or on the caps of a cylinder, any frame is used.
+func surfaceFrame(thing mat4, pos, normal vec3) (tangent, bitangent vec3) {
+	t, b := getThingTangents(thing, pos)
+	t = sub3(t, scale3(normal, dot3(normal, t)))
+	if length3(t) < 1e-6 {
+		return orthonormalBasis(normal)
+	}
+	tangent = normalize3(t)
+	b = sub3(b, add3(scale3(normal, dot3(normal, b)), scale3(tangent, dot3(tangent, b))))
+	if length3(b) < 1e-6 {
+		return tangent, cross3(normal, tangent)
+	}
+	return tangent, normalize3(b)
+}
+
+// perturbNormal returns the normal of the thing at the given point, tilted by the normal map or the bump of the texture.
+// The point and the normal are in the space of the texture.
+func perturbNormal(textures TexturesT, idx int, thing mat4, pos, normal vec3) vec3 {
+	texture := getTexture(textures, idx)
+	if int(texture[2].x) == ImageTexture {
+		// The normal map gives the normal in the surface frame, its components going from 0..1 to -1..1.
+		tangent, bitangent := surfaceFrame(thing, pos, normal)
+		m := sampleTexture(textures, idx, getThingUV(thing, pos))
+		strength := texture[3].x
+		n := add3(add3(scale3(tangent, (m.x*2-1)*strength), scale3(bitangent, (m.y*2-1)*strength)), scale3(normal, max(0.01, m.z*2-1)))
+		return normalize3(n)
+	}
+
+	// The bump height is the value of the texture, the normal is tilted against its slope.
+	eps := bumpEpsilon / length3(texture[3].xyz)
+	height := proceduralTexture(texture, pos).x
+	slope := scale3(newVec3(
+		proceduralTexture(texture, add3(pos, newVec3(eps, 0, 0))).x-height,
+		proceduralTexture(texture, add3(pos, newVec3(0, eps, 0))).x-height,
+		proceduralTexture(texture, add3(pos, newVec3(0, 0, eps))).x-height,
+	), 1/eps)
+	return normalize3(sub3(normal, sub3(slope, scale3(normal, dot3(normal, slope)))))
+}
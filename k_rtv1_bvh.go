@@ -0,0 +1,78 @@
+package main
+
+// This file holds the BVH (bounding volume hierarchy) traversal.
+// The tree is built on the CPU at load time (see bvh.go) and flattened in an array of nodes.
+// It compiles to both Go and Kage shader (after pre-processing).
+
+// bvhStackSize is the max depth of the tree, Kage needs a constant size for the traversal stack.
+const bvhStackSize = 32
+
+// bvhMaxLeafThings is the max number of things in a leaf, a triangle with normals counting for two.
+const bvhMaxLeafThings = 8
+
+// The first node is the header, the tree starts at index 1.
+// h[0].x = number of unbounded things (i.e. planes), they are at the start of the things and not in the tree
+// h[0].y = number of nodes, header included
+func newBVHHeader(unbounded, nodes int) mat4 {
+	return newMat4(
+		newVec4(float(unbounded), float(nodes), 0, 0),
+		newVec4(0, 0, 0, 0),
+		newVec4(0, 0, 0, 0),
+		newVec4(0, 0, 0, 0),
+	)
+}
+
+func getBVHHeader(in mat4) (unbounded, nodes int) {
+	return int(in[0].x), int(in[0].y)
+}
+
+// n[0].x = left child index for internal nodes, first thing index for leaves
+// n[0].y = right child index for internal nodes, number of things for leaves
+// n[0].z = isLeaf
+// n[1].xyz = bounds min
+// n[2].xyz = bounds max
+func newBVHNode(a, b int, isLeaf bool, lo, hi vec3) mat4 {
+	isLeafFloat := 0.0
+	if isLeaf {
+		isLeafFloat = 1.0
+	}
+	return newMat4(
+		newVec4(float(a), float(b), isLeafFloat, 0),
+		newVec4(lo.x, lo.y, lo.z, 0),
+		newVec4(hi.x, hi.y, hi.z, 0),
+		newVec4(0, 0, 0, 0),
+	)
+}
+
+func getBVHNode(in mat4) (a, b int, isLeaf bool, lo, hi vec3) {
+	return int(in[0].x), int(in[0].y), in[0].z != 0.0, in[1].xyz, in[2].xyz
+}
+
+// safeInverse returns 1/v, with a large value instead of the division by zero.
+// The sign doesn't matter for the slab test.
+func safeInverse(v float) float {
+	if abs(v) < 1e-8 {
+		return 1e8
+	}
+	return 1.0 / v
+}
+
+// hitAABB returns true if the ray crosses the box between minDist and maxDist (slab test).
+func hitAABB(rayStart, invDir, lo, hi vec3, minDist, maxDist float) bool {
+	tx1 := (lo.x - rayStart.x) * invDir.x
+	tx2 := (hi.x - rayStart.x) * invDir.x
+	tMin := min(tx1, tx2)
+	tMax := max(tx1, tx2)
+
+	ty1 := (lo.y - rayStart.y) * invDir.y
+	ty2 := (hi.y - rayStart.y) * invDir.y
+	tMin = max(tMin, min(ty1, ty2))
+	tMax = min(tMax, max(ty1, ty2))
+
+	tz1 := (lo.z - rayStart.z) * invDir.z
+	tz2 := (hi.z - rayStart.z) * invDir.z
+	tMin = max(tMin, min(tz1, tz2))
+	tMax = min(tMax, max(tz1, tz2))
+
+	return tMax >= max(tMin, minDist) && tMin <= maxDist
+}
@@ -0,0 +1,210 @@
+package main
+
+// This file holds the constructive solid geometry: things combined by union, intersection or difference.
+// Each child is a solid the ray goes through along a single interval, the ray enters the CSG
+// where the combination of the children it is in changes.
+
+const (
+	CSGUnion        = 1
+	CSGIntersection = 2
+	CSGDifference   = 3
+)
+
+// csgMaxChildren is the max number of children of a CSG.
+const csgMaxChildren = 4
+
+// csgMaxSlots is the max number of things of the children, each can be followed by its transform.
+const csgMaxSlots = csgMaxChildren * 2
+
+// csgInfinity stands for the unbounded ends of the intervals.
+const csgInfinity = 1e30
+
+// c[0].x = type - csg
+// c[0].y = index of the first child, the children are moved after the other things so they are not hit on their own
+// c[0].z = number of things of the children, transforms included
+// c[0].w = operation
+func newCSG(operation, first, slots int) mat4 {
+	return newMat4(
+		newVec4(CSGType, float(first), float(slots), float(operation)),
+		newVec4(0, 0, 0, 0),
+		newVec4(0, 0, 0, 0),
+		newVec4(0, 0, 0, 0),
+	)
+}
+
+func getCSG(in mat4) (operation, first, slots int) {
+	return int(in[0].w), int(in[0].y), int(in[0].z)
+}
+
+// The CSG children use the last slot of their encoding as a flag:
+// c[3].w = inverted, the solid is subtracted, its normals point inward.
+func isInvertedThing(thing mat4) bool {
+	return thing[3].w != 0
+}
+
+// csgMember returns whether a point is in the CSG, knowing which children it is in.
+func csgMember(operation int, inside [csgMaxChildren]bool, count int) bool {
+	inAny, inAll, inOthers := false, true, false
+	for k := 0; k < csgMaxChildren; k++ {
+		if k >= count {
+			break
+		}
+		inAny = inAny || inside[k]
+		inAll = inAll && inside[k]
+		if k > 0 {
+			inOthers = inOthers || inside[k]
+		}
+	}
+	if operation == CSGIntersection {
+		return inAll
+	} else if operation == CSGDifference {
+		return inside[0] && !inOthers
+	}
+	return inAny
+}
+
+// hitCSG returns the distance to the CSG at the given index and the index of the child hit.
+// The children intervals bounds are visited in order, the surface is where the membership changes.
+func hitCSG(rayStart, rayDir vec3, things ThingsT, idx int, minDist, maxDist float) (t float, hitIdx int) {
+	operation, first, slots := getCSG(getThing(things, idx))
+
+	var childIdx [csgMaxChildren]int
+	var tIn, tOut [csgMaxChildren]float
+	var inDone, outDone, inside [csgMaxChildren]bool
+	count := 0
+	for s := 0; s < csgMaxSlots; s++ {
+		i := first + s
+		if s >= slots || i >= getThingCount(things) {
+			break
+		}
+		if getThingType(getThing(things, i)) == TransformType || count >= csgMaxChildren {
+			continue
+		}
+		start, dir := rayStart, rayDir
+		if transform, ok := getThingTransform(things, i); ok {
+			start, dir = transformPoint(transform, rayStart), transformDir(transform, rayDir)
+		}
+		a, b, ok := thingInterval(start, dir, getThing(things, i))
+		childIdx[count] = i
+		tIn[count], tOut[count] = a, b
+		// The bounds before the ray start only set whether it starts inside.
+		inDone[count] = !ok || a < minDist
+		outDone[count] = !ok || b < minDist
+		inside[count] = ok && a < minDist && b >= minDist
+		count++
+	}
+
+	member := csgMember(operation, inside, count)
+	for e := 0; e < csgMaxChildren*2; e++ {
+		// Next bound, the entries first for equal distances.
+		next, nextT, nextIn := -1, 0.0, false
+		for k := 0; k < csgMaxChildren; k++ {
+			if k >= count {
+				break
+			}
+			if !inDone[k] && (next == -1 || tIn[k] < nextT) {
+				next, nextT, nextIn = k, tIn[k], true
+			}
+			if !outDone[k] && (next == -1 || tOut[k] < nextT) {
+				next, nextT, nextIn = k, tOut[k], false
+			}
+		}
+		if next == -1 || nextT >= csgInfinity || (maxDist != -1 && nextT > maxDist) {
+			break
+		}
+		if nextIn {
+			inDone[next] = true
+		} else {
+			outDone[next] = true
+		}
+		inside[next] = nextIn
+
+		if m := csgMember(operation, inside, count); m != member {
+			return nextT, childIdx[next]
+		}
+	}
+	return 0, idx
+}
+
+// thingInterval returns the distances where the ray enters and exits the solid thing, ok is false when it misses.
+// The cylinders and cones are closed solids, the planes are half spaces behind their normal.
+func thingInterval(rayStart, rayDir vec3, thing mat4) (tIn, tOut float, ok bool) {
+	if t := getThingType(thing); t == SphereType {
+		return sphereRoots(rayStart, rayDir, thing)
+	} else if t == PlaneType {
+		return planeInterval(rayStart, rayDir, thing)
+	} else if t == CylinderType {
+		return cylinderInterval(rayStart, rayDir, thing)
+	} else if t == ConeType {
+		return coneInterval(rayStart, rayDir, thing)
+	} else if t == BoxType {
+		return boxInterval(rayStart, rayDir, thing)
+	}
+	return 0, 0, false
+}
+
+// slabInterval returns the distances where the ray is between the planes perpendicular to the axis,
+// at the given distances from the origin along the axis.
+func slabInterval(rayStart, rayDir, origin, axisDir vec3, lo, hi float) (tIn, tOut float, ok bool) {
+	h := dot3(sub3(rayStart, origin), axisDir)
+	rdDotAxis := dot3(rayDir, axisDir)
+	if abs(rdDotAxis) < 1e-8 {
+		return -csgInfinity, csgInfinity, h >= lo && h <= hi
+	}
+	t1, t2 := (lo-h)/rdDotAxis, (hi-h)/rdDotAxis
+	return min(t1, t2), max(t1, t2), true
+}
+
+func planeInterval(rayStart, rayDir vec3, thing mat4) (tIn, tOut float, ok bool) {
+	pPos, pNorm := getPlane(thing)
+	return slabInterval(rayStart, rayDir, pPos, pNorm, -csgInfinity, 0)
+}
+
+func cylinderInterval(rayStart, rayDir vec3, thing mat4) (tIn, tOut float, ok bool) {
+	center1, center2, _ := getCylinder(thing)
+	_, infinite := getCylinderOptions(thing)
+	axis := sub3(center2, center1)
+
+	// Inside the infinite cylinder.
+	a, b, c := cylinderQuadratic(rayStart, rayDir, thing)
+	tIn, tOut, ok = solveQuadratic(a, b, c)
+	if abs(a) < 1e-8 { // Parallel to the axis, inside all along or never.
+		tIn, tOut, ok = -csgInfinity, csgInfinity, c <= 0
+	}
+	if !ok || infinite {
+		return tIn, tOut, ok
+	}
+
+	// Between the caps.
+	sIn, sOut, sOk := slabInterval(rayStart, rayDir, center1, normalize3(axis), 0, length3(axis))
+	tIn, tOut = max(tIn, sIn), min(tOut, sOut)
+	return tIn, tOut, sOk && tIn <= tOut
+}
+
+func coneInterval(rayStart, rayDir vec3, thing mat4) (tIn, tOut float, ok bool) {
+	base, apex, _, _ := getCone(thing)
+	axis := sub3(base, apex)
+
+	// Between the apex and the base, which also excludes the other side of the double cone.
+	sIn, sOut, sOk := slabInterval(rayStart, rayDir, apex, normalize3(axis), 0, length3(axis))
+	if !sOk {
+		return 0, 0, false
+	}
+
+	// Inside the double cone: between the roots when a < 0, outside of them when a > 0.
+	a, b, c := coneQuadratic(rayStart, rayDir, thing)
+	t0, t1, rootsOk := solveQuadratic(a, b, c)
+	if !rootsOk { // Never or always inside, a ray parallel to the side is ignored.
+		return sIn, sOut, a >= 1e-8
+	}
+	if a < 0 {
+		tIn, tOut = max(t0, sIn), min(t1, sOut)
+		return tIn, tOut, tIn <= tOut
+	}
+	// Only one of the two sides is within the slab.
+	if sIn <= min(t0, sOut) {
+		return sIn, min(t0, sOut), true
+	}
+	tIn = max(t1, sIn)
+	return tIn, sOut, tIn <= sOut
+}
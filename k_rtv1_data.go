@@ -0,0 +1,30 @@
+package main
+
+// This file holds the decoding of the scene data packed in the source image.
+// The things and the BVH nodes of the large scenes don't fit in the uniforms, so they are stored
+// in the source image of the shader, below the texture atlas, one float per texel, see packSceneData.
+
+// maxDataItems is the max number of things or BVH nodes, bounding the loops over them.
+// The shader needs constant bounds, the data being at most sourceMaxWidth*sourceMaxWidth texels of 16 floats.
+const maxDataItems = 1048576
+
+// decodeDataFloat returns the float stored in the texel as its float32 bits, the most significant byte in the red channel.
+// The denormals are flushed to 0, the infinities and NaNs are not supported.
+func decodeDataFloat(texel vec4) float {
+	b0 := int(floor(texel.x*255 + 0.5))
+	b1 := int(floor(texel.y*255 + 0.5))
+	b2 := int(floor(texel.z*255 + 0.5))
+	b3 := int(floor(texel.w*255 + 0.5))
+
+	sign := 1.0
+	if b0 >= 128 {
+		sign = -1.0
+		b0 -= 128
+	}
+	exponent := b0*2 + b1/128
+	if exponent == 0 {
+		return 0
+	}
+	mantissa := float((b1-b1/128*128)*65536 + b2*256 + b3)
+	return sign * (1 + mantissa/8388608) * exp2(float(exponent-127))
+}
@@ -1,17 +1,136 @@
 package main
 
-// p[1].xyz = center
+const (
+	PointLightType       = 1
+	DirectionalLightType = 2
+	SpotLightType        = 3
+	RectLightType        = 4
+	DiscLightType        = 5
+	SphereLightType      = 6
+)
+
+// maxLightSamples is the upper bound of the number of shadow rays per area light.
+const maxLightSamples = 64
+
+// p[0].x = type
+// p[0].y = cos of the inner angle (spot)
+// p[0].z = cos of the outer angle (spot)
+// p[1].xyz = center (point, spot, area)
 // p[1].w = intensity
-// p[1].xyzw = color
-func newLight(center vec3, color vec4, intensity float) mat4 {
+// p[2].xyzw = color
+// p[3].xyz = direction the light goes toward, normalized (directional, spot)
+func newLight(lightType int, center, direction vec3, color vec4, intensity, cosInner, cosOuter float) mat4 {
 	return newMat4(
-		newVec4(0, 0, 0, 0),
+		newVec4(float(lightType), cosInner, cosOuter, 0),
 		newVec4(center.x, center.y, center.z, intensity),
 		color,
-		newVec4(0, 0, 0, 0),
+		newVec4(direction.x, direction.y, direction.z, 0),
+	)
+}
+
+// The area lights have the same center, intensity and color as the other lights, then:
+// p[0].yzw = second half edge (rect)
+// p[2].w = number of shadow samples, the alpha of the light color is not used
+// p[3].xyz = first half edge (rect), normal (disc)
+// p[3].w = radius (disc, sphere)
+func newAreaLight(lightType int, center, axis, edge vec3, radius float, color vec4, intensity float, samples int) mat4 {
+	return newMat4(
+		newVec4(float(lightType), edge.x, edge.y, edge.z),
+		newVec4(center.x, center.y, center.z, intensity),
+		newVec4(color.x, color.y, color.z, float(samples)),
+		newVec4(axis.x, axis.y, axis.z, radius),
 	)
 }
 
+// getLightType returns the type of the light, 0 for empty slots.
+func getLightType(in mat4) float {
+	return in[0].x
+}
+
 func getLight(in mat4) (center vec3, color vec4, intensity float) {
 	return in[1].xyz, in[2], in[1].w
 }
+
+func isAreaLight(in mat4) bool {
+	t := getLightType(in)
+	return t == RectLightType || t == DiscLightType || t == SphereLightType
+}
+
+// getLightSamples returns the number of shadow rays to cast toward the light.
+func getLightSamples(in mat4) int {
+	if isAreaLight(in) {
+		return int(in[2].w)
+	}
+	return 1
+}
+
+// getLightIncidence returns the direction from the given point to the light, the distance to the light,
+// -1 when infinitely far, and the attenuation of the light at the point, 0 when not lit.
+// For the area lights, the light is seen from a random point of the cell of the given sample,
+// the cells splitting the light in a grid, so the samples are spread over the whole light.
+func getLightIncidence(in mat4, pos vec3, sample, samples, seed int) (lightDir vec3, lightDistance, attenuation float) {
+	t := getLightType(in)
+	center, _, _ := getLight(in)
+	direction := in[3].xyz
+
+	// The sun, same direction everywhere, no attenuation.
+	if t == DirectionalLightType {
+		return scale3(direction, -1), -1, 1
+	}
+
+	normal := newVec3(0, 0, 0) // The flat lights emit less light sideways.
+	if isAreaLight(in) {
+		cols := int(ceil(sqrt(float(samples))))
+		rows := (samples + cols - 1) / cols
+		u := (float(sample%cols) + random(hashSeed(seed, 2*sample))) / float(cols)
+		v := (float(sample/cols) + random(hashSeed(seed, 2*sample+1))) / float(rows)
+
+		if t == RectLightType {
+			edge := newVec3(in[0].y, in[0].z, in[0].w)
+			center = quadPoint(center, direction, edge, newVec2(u, v))
+			normal = normalize3(cross3(direction, edge))
+		} else {
+			// The disc itself, or the disc of the sphere facing the point.
+			axis := direction
+			if t == SphereLightType {
+				axis = normalize3(sub3(pos, center))
+			} else {
+				normal = direction
+			}
+			tangent, bitangent := orthonormalBasis(axis)
+			r, a := in[3].w*sqrt(u), 2*pi*v
+			center = add3(center, add3(scale3(tangent, r*cos(a)), scale3(bitangent, r*sin(a))))
+		}
+	}
+
+	lightDir = sub3(center, pos)
+	lightDistance = length3(lightDir)
+	lightDir = normalize3(lightDir)
+
+	// Inverse square law.
+	attenuation = 1.0 / (lightDistance * lightDistance)
+
+	if t == SpotLightType {
+		// Smooth falloff between the inner and outer cones.
+		cosInner, cosOuter := in[0].y, in[0].z
+		cosAngle := dot3(scale3(lightDir, -1), direction)
+		f := min(1, max(0, (cosAngle-cosOuter)/max(cosInner-cosOuter, 1e-6)))
+		attenuation *= f * f * (3 - 2*f)
+	} else if length3(normal) > 0 {
+		// Both sides of the flat lights emit.
+		attenuation *= abs(dot3(lightDir, normal))
+	}
+
+	return lightDir, lightDistance, attenuation
+}
+
+// orthonormalBasis returns two unit vectors perpendicular to the given normal and to each other.
+func orthonormalBasis(normal vec3) (tangent, bitangent vec3) {
+	helper := newVec3(1, 0, 0)
+	if abs(normal.x) > 0.9 {
+		helper = newVec3(0, 1, 0)
+	}
+	tangent = normalize3(cross3(helper, normal))
+	bitangent = cross3(normal, tangent)
+	return tangent, bitangent
+}
@@ -1,18 +1,24 @@
 package main
 
-// p[0].x = type
+// p[0].x = normal map or bump texture index + 1, 0 without
 // p[1].xyzw = color
 // p[0].y = ambient
-// p[0].z = diffuse
-// p[0].w = specular
-// p[2].x = specularPower
+// p[0].z = diffuse, metallic for the pbr materials
+// p[0].w = specular, roughness for the pbr materials
+// p[2].x = specularPower, PBRModel for the pbr materials
 // p[2].y = reflectiveIndex
-func newMaterial(mType int, color vec4, ambient, diffuse, specular, specularPower, reflectiveIndex float) mat4 {
+// p[2].z = transparency
+// p[2].w = refractiveIndex
+// p[3].x = texture index + 1, 0 without texture
+// p[3].y = pattern type, 0 without pattern
+// p[3].z = pattern size
+// p[3].w = index of the second material of the pattern
+func newMaterial(color vec4, ambient, diffuse, specular, specularPower, reflectiveIndex, transparency, refractiveIndex float, textureIdx, patternType int, patternSize float, patternMaterialIdx, normalTextureIdx int) mat4 {
 	return newMat4(
-		newVec4(float(mType), ambient, diffuse, specular),
+		newVec4(float(normalTextureIdx+1), ambient, diffuse, specular),
 		newVec4(color.x, color.y, color.z, color.w),
-		newVec4(specularPower, reflectiveIndex, 0, 0),
-		newVec4(0, 0, 0, 0),
+		newVec4(specularPower, reflectiveIndex, transparency, refractiveIndex),
+		newVec4(float(textureIdx+1), float(patternType), patternSize, float(patternMaterialIdx)),
 	)
 }
 
@@ -26,3 +32,24 @@ func getMaterial(materials MaterialsT, idx int) (color vec4, ambient, diffuse, s
 	reflectiveIndex = m[2].y
 	return color, ambient, diffuse, specular, specularPower, reflectiveIndex
 }
+
+func getMaterialTransparency(materials MaterialsT, idx int) (transparency, refractiveIndex float) {
+	m := materials[idx]
+	return m[2].z, m[2].w
+}
+
+// getMaterialTexture returns the index of the texture of the material, -1 without texture.
+func getMaterialTexture(materials MaterialsT, idx int) int {
+	return int(materials[idx][3].x) - 1
+}
+
+// getMaterialPattern returns the pattern of the material, type 0 without pattern, and the index of its second material.
+func getMaterialPattern(materials MaterialsT, idx int) (pType int, size float, materialIdx int) {
+	m := materials[idx]
+	return int(m[3].y), m[3].z, int(m[3].w)
+}
+
+// getMaterialNormalTexture returns the index of the normal map or bump texture of the material, -1 without.
+func getMaterialNormalTexture(materials MaterialsT, idx int) int {
+	return int(materials[idx][0].x) - 1
+}
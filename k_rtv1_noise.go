@@ -0,0 +1,111 @@
+package main
+
+// This file holds the 3D noise of the procedural textures.
+// The lattice is hashed with the same function as the sampling, so the CPU and the GPU get the same noise.
+
+const (
+	NoisePerlin = 1 // Gradient noise.
+	NoiseValue  = 2
+)
+
+// maxNoiseOctaves is the max number of octaves summed by fractalNoise and turbulence.
+const maxNoiseOctaves = 8
+
+// latticeHash returns the hash of the given lattice point.
+func latticeHash(x, y, z int) int {
+	return hash(x ^ hash(y^hash(z)))
+}
+
+// latticeValue returns the contribution of the lattice point at the offset d from the sampled point, from -1 to 1.
+// Perlin noise uses one of the 12 gradients toward the edges of the cube, value noise a random value.
+func latticeValue(x, y, z int, d vec3, kind int) float {
+	h := latticeHash(x, y, z)
+	if kind == NoiseValue {
+		return random(h)*2 - 1
+	}
+
+	h &= 15
+	u := d.y
+	if h < 8 {
+		u = d.x
+	}
+	v := d.z
+	if h < 4 {
+		v = d.y
+	} else if h == 12 || h == 14 {
+		v = d.x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// fade is the quintic easing of the interpolation, smooth up to the second derivative.
+func fade(t float) float {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(a, b, t float) float {
+	return a + (b-a)*t
+}
+
+// noise3 returns the noise at the given point, from -1 to 1.
+func noise3(p vec3, kind int) float {
+	fx, fy, fz := floor(p.x), floor(p.y), floor(p.z)
+	x, y, z := int(fx), int(fy), int(fz)
+	dx, dy, dz := p.x-fx, p.y-fy, p.z-fz
+	u, v, w := fade(dx), fade(dy), fade(dz)
+
+	c000 := latticeValue(x, y, z, newVec3(dx, dy, dz), kind)
+	c100 := latticeValue(x+1, y, z, newVec3(dx-1, dy, dz), kind)
+	c010 := latticeValue(x, y+1, z, newVec3(dx, dy-1, dz), kind)
+	c110 := latticeValue(x+1, y+1, z, newVec3(dx-1, dy-1, dz), kind)
+	c001 := latticeValue(x, y, z+1, newVec3(dx, dy, dz-1), kind)
+	c101 := latticeValue(x+1, y, z+1, newVec3(dx-1, dy, dz-1), kind)
+	c011 := latticeValue(x, y+1, z+1, newVec3(dx, dy-1, dz-1), kind)
+	c111 := latticeValue(x+1, y+1, z+1, newVec3(dx-1, dy-1, dz-1), kind)
+
+	front := lerp(lerp(c000, c100, u), lerp(c010, c110, u), v)
+	back := lerp(lerp(c001, c101, u), lerp(c011, c111, u), v)
+	return min(1, max(-1, lerp(front, back, w)))
+}
+
+// fractalNoise sums the given number of octaves of noise, each one twice the frequency and half the amplitude
+// of the previous one. The result is from -1 to 1.
+func fractalNoise(p vec3, octaves, kind int) float {
+	var sum, total float
+	amplitude := 1.0
+	q := p
+	for i := 0; i < maxNoiseOctaves; i++ {
+		if i >= octaves {
+			break
+		}
+		sum += amplitude * noise3(q, kind)
+		total += amplitude
+		amplitude *= 0.5
+		q = scale3(q, 2)
+	}
+	return sum / total
+}
+
+// turbulence is fractalNoise with the absolute value of each octave, giving sharp creases.
+// The result is from 0 to 1.
+func turbulence(p vec3, octaves, kind int) float {
+	var sum, total float
+	amplitude := 1.0
+	q := p
+	for i := 0; i < maxNoiseOctaves; i++ {
+		if i >= octaves {
+			break
+		}
+		sum += amplitude * abs(noise3(q, kind))
+		total += amplitude
+		amplitude *= 0.5
+		q = scale3(q, 2)
+	}
+	return sum / total
+}
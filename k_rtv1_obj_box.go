@@ -0,0 +1,101 @@
+package main
+
+// b[0].x = type - box
+// b[0].y = materialIdx
+// b[0].z = half size along the first axis
+// b[0].w = half size along the second axis
+// b[1].xyz = center
+// b[1].w = half size along the third axis
+// b[2].xyz = first axis, normalized
+// b[3].xyz = second axis, normalized and perpendicular to the first one, the third one is their cross product
+func newBox(center, axisX, axisY, halfSize vec3, materialIdx int) mat4 {
+	return newMat4(
+		newVec4(BoxType, float(materialIdx), halfSize.x, halfSize.y),
+		newVec4(center.x, center.y, center.z, halfSize.z),
+		newVec4(axisX.x, axisX.y, axisX.z, 0),
+		newVec4(axisY.x, axisY.y, axisY.z, 0),
+	)
+}
+
+func getBox(in mat4) (center, axisX, axisY, axisZ, halfSize vec3) {
+	return in[1].xyz, in[2].xyz, in[3].xyz, cross3(in[2].xyz, in[3].xyz), newVec3(in[0].z, in[0].w, in[1].w)
+}
+
+// boxLocal returns the position in the frame of the box, scaled to -1..1 inside of it.
+func boxLocal(thing mat4, pos vec3) vec3 {
+	center, axisX, axisY, axisZ, halfSize := getBox(thing)
+	p := sub3(pos, center)
+	return newVec3(dot3(p, axisX)/halfSize.x, dot3(p, axisY)/halfSize.y, dot3(p, axisZ)/halfSize.z)
+}
+
+func diffuseBox(thing mat4, pos vec3, materials MaterialsT) vec4 {
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
+
+// normalBox returns the axis of the face the point is on, the one it is the closest to in the scaled frame.
+func normalBox(thing mat4, pos vec3) vec3 {
+	_, axisX, axisY, axisZ, _ := getBox(thing) //nolint:dogsled // Expected.
+	l := boxLocal(thing, pos)
+
+	normal, side := axisX, l.x
+	if abs(l.y) > abs(side) {
+		normal, side = axisY, l.y
+	}
+	if abs(l.z) > abs(side) {
+		normal, side = axisZ, l.z
+	}
+	if side < 0 {
+		return scale3(normal, -1)
+	}
+	return normal
+}
+
+// uvBox returns the coordinates on the face the point is on, each face covering the whole 0..1 range.
+func uvBox(thing mat4, pos vec3) vec2 {
+	l := boxLocal(thing, pos)
+	a, b := l.y, l.z
+	if abs(l.y) >= abs(l.x) && abs(l.y) >= abs(l.z) {
+		a, b = l.x, l.z
+	} else if abs(l.z) >= abs(l.x) && abs(l.z) >= abs(l.y) {
+		a, b = l.x, l.y
+	}
+	return newVec2((a+1)/2, (b+1)/2)
+}
+
+func tangentsBox(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	_, axisX, axisY, axisZ, _ := getBox(thing) //nolint:dogsled // Expected.
+	l := boxLocal(thing, pos)
+	if abs(l.y) >= abs(l.x) && abs(l.y) >= abs(l.z) {
+		return axisX, axisZ
+	} else if abs(l.z) >= abs(l.x) && abs(l.z) >= abs(l.y) {
+		return axisX, axisY
+	}
+	return axisY, axisZ
+}
+
+// boxInterval returns the distances where the ray enters and exits the box, where it is within the 3 slabs.
+func boxInterval(rayStart, rayDir vec3, thing mat4) (tIn, tOut float, ok bool) {
+	center, axisX, axisY, axisZ, halfSize := getBox(thing)
+
+	xIn, xOut, xOk := slabInterval(rayStart, rayDir, center, axisX, -halfSize.x, halfSize.x)
+	yIn, yOut, yOk := slabInterval(rayStart, rayDir, center, axisY, -halfSize.y, halfSize.y)
+	zIn, zOut, zOk := slabInterval(rayStart, rayDir, center, axisZ, -halfSize.z, halfSize.z)
+	tIn, tOut = max(xIn, max(yIn, zIn)), min(xOut, min(yOut, zOut))
+	return tIn, tOut, xOk && yOk && zOk && tIn <= tOut
+}
+
+func hitBox(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	tIn, tOut, ok := boxInterval(rayStart, rayDir, thing)
+	if !ok {
+		return 0
+	}
+	if inDistRange(tIn, minDist, maxDist) {
+		return tIn
+	}
+	// From inside the box.
+	if inDistRange(tOut, minDist, maxDist) {
+		return tOut
+	}
+	return 0
+}
@@ -5,12 +5,21 @@ package main
 // c[0].z = radius
 // c[0].w = radius^2
 // c[1].xyz = base center
+// c[1].w = capped, closed by a disc at the base
 // c[2].xyz = apex center
-func newCone(base, apex vec3, radius float, materialIdx int) mat4 {
+// c[2].w = infinite, both sides of the apex, base - apex is then only the axis
+func newCone(base, apex vec3, radius float, capped, infinite bool, materialIdx int) mat4 {
+	cappedFloat, infiniteFloat := 0.0, 0.0
+	if capped {
+		cappedFloat = 1.0
+	}
+	if infinite {
+		infiniteFloat = 1.0
+	}
 	return newMat4(
 		newVec4(ConeType, float(materialIdx), radius, radius*radius),
-		newVec4(base.x, base.y, base.z, 0),
-		newVec4(apex.x, apex.y, apex.z, 0),
+		newVec4(base.x, base.y, base.z, cappedFloat),
+		newVec4(apex.x, apex.y, apex.z, infiniteFloat),
 		newVec4(0, 0, 0, 0),
 	)
 }
@@ -23,6 +32,21 @@ func diffuseCone(thing mat4, pos vec3, materials MaterialsT) vec4 {
 	return getMaterialColor(materials, getThingMaterialIdx(thing))
 }
 
+// uvCone returns the angle around the axis, from 0 to 1, and the height along the axis,
+// from 0 at the apex to 1 at the base.
+func uvCone(thing mat4, pos vec3) vec2 {
+	base, apex, _, _ := getCone(thing)
+	axis := sub3(base, apex)
+	p := sub3(pos, apex)
+	return newVec2(angleAround(p, normalize3(axis)), dot3(p, axis)/dot3(axis, axis))
+}
+
+func tangentsCone(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	base, apex, _, _ := getCone(thing)
+	axis := sub3(base, apex)
+	return angleTangent(sub3(pos, apex), normalize3(axis)), axis
+}
+
 func normalCone(thing mat4, pos vec3) vec3 {
 	base, apex, radius, _ := getCone(thing)
 
@@ -33,18 +57,28 @@ func normalCone(thing mat4, pos vec3) vec3 {
 	v := sub3(pos, apex)
 	projLen := dot3(v, axisDir)
 
+	// The cap faces away from the apex.
+	if capped, _ := getCylinderOptions(thing); capped && projLen > axisLength-capEpsilon {
+		return axisDir
+	}
+
 	// Project hit point onto axis.
 	axisPoint := add3(apex, scale3(axisDir, projLen))
 
 	perpComp := sub3(pos, axisPoint)
 
-	normal := add3(perpComp, scale3(axisDir, -radius/axisLength))
+	// The slope of the side, scaled by the distance to the apex, which also orients the normal
+	// of the other side of infinite cones.
+	tanTheta := radius / axisLength
+	normal := sub3(perpComp, scale3(axisDir, projLen*tanTheta*tanTheta))
 	normal = normalize3(normal)
 
 	return normal
 }
 
-func hitCone(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+// coneQuadratic returns the coefficients of the quadratic equation of the distances where the ray line
+// crosses the side of the infinite double cone. The polynomial is positive inside the cone.
+func coneQuadratic(rayStart, rayDir vec3, thing mat4) (a, b, c float) {
 	base, apex, _, radius2 := getCone(thing)
 
 	// Vector from apex to base center.
@@ -66,58 +100,37 @@ func hitCone(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
 	ocDotAxis := dot3(oc, axisDir)
 
 	// Quadratic equation coefficients.
-	a := rdDotAxis*rdDotAxis - cosTheta2*dot3(rayDir, rayDir)
-	b := 2.0 * (rdDotAxis*ocDotAxis - cosTheta2*dot3(rayDir, oc))
-	c := ocDotAxis*ocDotAxis - cosTheta2*dot3(oc, oc)
-
-	// Check discriminant.
-	discriminant := b*b - 4.0*a*c
-	if discriminant < 0.0 {
-		return 0
-	}
-
-	// Calculate intersection.
-	sqrtd := sqrt(discriminant)
+	a = rdDotAxis*rdDotAxis - cosTheta2*dot3(rayDir, rayDir)
+	b = 2.0 * (rdDotAxis*ocDotAxis - cosTheta2*dot3(rayDir, oc))
+	c = ocDotAxis*ocDotAxis - cosTheta2*dot3(oc, oc)
+	return a, b, c
+}
 
-	// Calculate both intersection points.
-	t0 := (-b - sqrtd) / (2.0 * a)
-	t1 := (-b + sqrtd) / (2.0 * a)
+func hitCone(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	base, apex, radius, _ := getCone(thing)
+	capped, infinite := getCylinderOptions(thing)
 
-	// Ensure t0 <= t1.
-	if t0 > t1 {
-		t0, t1 = t1, t0
-	}
+	axis := sub3(base, apex)
+	axisLength := length3(axis)
+	axisDir := normalize3(axis)
 
-	// Check both intersection points.
-	t := t0
-	if t < minDist || (maxDist != -1 && t > maxDist) {
-		t = t1
-		if t < minDist || (maxDist != -1 && t > maxDist) {
-			return 0
+	// A ray parallel to the side doesn't hit it.
+	t := 0.0
+	a, b, c := coneQuadratic(rayStart, rayDir, thing)
+	if t0, t1, ok := solveQuadratic(a, b, c); ok {
+		// Keep the first intersection within the ray bounds and the cone height (between apex and base).
+		// The infinite cones have both sides of the apex.
+		projLen0 := dot3(sub3(add3(rayStart, scale3(rayDir, t0)), apex), axisDir)
+		projLen1 := dot3(sub3(add3(rayStart, scale3(rayDir, t1)), apex), axisDir)
+		if inDistRange(t0, minDist, maxDist) && (infinite || (projLen0 >= 0.0 && projLen0 <= axisLength)) {
+			t = t0
+		} else if inDistRange(t1, minDist, maxDist) && (infinite || (projLen1 >= 0.0 && projLen1 <= axisLength)) {
+			t = t1
 		}
 	}
 
-	// Calculate intersection point.
-	hitPoint := add3(rayStart, scale3(rayDir, t))
-
-	// Check if intersection is within cone height (between apex and base).
-	v := sub3(hitPoint, apex)
-	projLen := dot3(v, axisDir)
-
-	if projLen < 0.0 || projLen > axisLength {
-		// Try other intersection.
-		t = t1
-		if t < minDist || (maxDist != -1 && t > maxDist) {
-			return 0
-		}
-
-		hitPoint = add3(rayStart, scale3(rayDir, t))
-		v = sub3(hitPoint, apex)
-		projLen = dot3(v, axisDir)
-
-		if projLen < 0.0 || projLen > axisLength {
-			return 0
-		}
+	if capped && !infinite {
+		t = closestHit(t, discDistance(rayStart, rayDir, base, axisDir, radius, minDist, maxDist))
 	}
 
 	return t
@@ -4,12 +4,21 @@ package main
 // c[0].y = materialIdx
 // c[0].z = radius
 // c[1].xyz = center1
+// c[1].w = capped, closed by discs at both ends
 // c[2].xyz = center2
-func newCylinder(center1, center2 vec3, radius float, materialIndex int) mat4 {
+// c[2].w = infinite, center2 - center1 is then only the axis
+func newCylinder(center1, center2 vec3, radius float, capped, infinite bool, materialIndex int) mat4 {
+	cappedFloat, infiniteFloat := 0.0, 0.0
+	if capped {
+		cappedFloat = 1.0
+	}
+	if infinite {
+		infiniteFloat = 1.0
+	}
 	return newMat4(
 		newVec4(CylinderType, float(materialIndex), radius, 0),
-		newVec4(center1.x, center1.y, center1.z, 0),
-		newVec4(center2.x, center2.y, center2.z, 0),
+		newVec4(center1.x, center1.y, center1.z, cappedFloat),
+		newVec4(center2.x, center2.y, center2.z, infiniteFloat),
 		newVec4(0, 0, 0, 0),
 	)
 }
@@ -18,11 +27,35 @@ func getCylinder(in mat4) (center1, center2 vec3, radius float) {
 	return in[1].xyz, in[2].xyz, in[0].z
 }
 
+// getCylinderOptions returns whether the cylinder is capped and whether it is infinite.
+// Cones use the same slots.
+func getCylinderOptions(in mat4) (capped, infinite bool) {
+	return in[1].w != 0.0, in[2].w != 0.0
+}
+
 func diffuseCylinder(thing mat4, pos vec3, materials MaterialsT) vec4 {
 	_ = pos
 	return getMaterialColor(materials, getThingMaterialIdx(thing))
 }
 
+// uvCylinder returns the angle around the axis, from 0 to 1, and the height along the axis,
+// from 0 at center1 to 1 at center2.
+func uvCylinder(thing mat4, pos vec3) vec2 {
+	center1, center2, _ := getCylinder(thing)
+	axis := sub3(center2, center1)
+	p := sub3(pos, center1)
+	return newVec2(angleAround(p, normalize3(axis)), dot3(p, axis)/dot3(axis, axis))
+}
+
+func tangentsCylinder(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	center1, center2, _ := getCylinder(thing)
+	axis := sub3(center2, center1)
+	return angleTangent(sub3(pos, center1), normalize3(axis)), axis
+}
+
+// capEpsilon is the distance to the end plane under which a hit point is considered on the cap.
+const capEpsilon = 1e-6
+
 func normalCylinder(thing mat4, pos vec3) vec3 {
 	center1, center2, _ := getCylinder(thing)
 
@@ -31,6 +64,15 @@ func normalCylinder(thing mat4, pos vec3) vec3 {
 
 	hitPointOnAxis := dot3(sub3(pos, center1), axisDir)
 
+	// The caps face outward along the axis.
+	if capped, _ := getCylinderOptions(thing); capped {
+		if hitPointOnAxis < capEpsilon {
+			return scale3(axisDir, -1)
+		} else if hitPointOnAxis > length3(axis)-capEpsilon {
+			return axisDir
+		}
+	}
+
 	pointOnAxis := add3(center1, scale3(axisDir, hitPointOnAxis))
 
 	normal := sub3(pos, pointOnAxis)
@@ -39,13 +81,38 @@ func normalCylinder(thing mat4, pos vec3) vec3 {
 	return normal
 }
 
-func hitCylinder(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+// discDistance returns the distance to the disc, 0 if not hit.
+func discDistance(rayStart, rayDir, center, normal vec3, radius, minDist, maxDist float) float {
+	denom := dot3(rayDir, normal)
+	if abs(denom) < 1e-8 {
+		return 0
+	}
+	t := dot3(sub3(center, rayStart), normal) / denom
+	if !inDistRange(t, minDist, maxDist) {
+		return 0
+	}
+	d := sub3(add3(rayStart, scale3(rayDir, t)), center)
+	if dot3(d, d) > radius*radius {
+		return 0
+	}
+	return t
+}
+
+// closestHit returns the closest of the two distances, ignoring the misses.
+func closestHit(t1, t2 float) float {
+	if t1 == 0 || (t2 != 0 && t2 < t1) {
+		return t2
+	}
+	return t1
+}
+
+// cylinderQuadratic returns the coefficients of the quadratic equation of the distances where the ray line
+// crosses the side of the infinite cylinder. The polynomial is negative inside the cylinder.
+func cylinderQuadratic(rayStart, rayDir vec3, thing mat4) (a, b, c float) {
 	center1, center2, radius := getCylinder(thing)
 
 	// Get axis information.
-	axis := sub3(center2, center1)
-	axisLength := length3(axis)
-	axisDir := normalize3(axis)
+	axisDir := normalize3(sub3(center2, center1))
 
 	// Vector from ray start to center1.
 	oc := sub3(rayStart, center1)
@@ -64,48 +131,38 @@ func hitCylinder(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) floa
 	ocPerp := sub3(oc, scale3(axisDir, ocDotAxis))
 
 	// Set up quadratic equation coefficients.
-	a := rayDirPerpLenSq
-	if abs(a) < 1e-8 { // If ray is parallel to cylinder axis, no intersection.
-		return 0
-	}
-	b := 2.0 * dot3(rayDirPerp, ocPerp)
-	c := dot3(ocPerp, ocPerp) - radius*radius
-	discriminant := b*b - 4.0*a*c
-
-	if discriminant < 0.0 {
-		return 0
-	}
-
-	// Find closest intersection.
-	sqrtd := sqrt(discriminant)
-	t1 := (-b - sqrtd) / (2.0 * a)
-	t2 := (-b + sqrtd) / (2.0 * a)
+	a = rayDirPerpLenSq
+	b = 2.0 * dot3(rayDirPerp, ocPerp)
+	c = dot3(ocPerp, ocPerp) - radius*radius
+	return a, b, c
+}
 
-	// Ensure t1 <= t2.
-	if t1 > t2 {
-		t1, t2 = t2, t1
-	}
+func hitCylinder(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	center1, center2, radius := getCylinder(thing)
+	capped, infinite := getCylinderOptions(thing)
 
-	t := t1
-	// Check if first intersection is within bounds.
-	hitPoint := add3(rayStart, scale3(rayDir, t))
-	hitPointOnAxis := dot3(sub3(hitPoint, center1), axisDir)
+	axis := sub3(center2, center1)
+	axisLength := length3(axis)
+	axisDir := normalize3(axis)
 
-	// If first hit is outside cylinder height, try second intersection.
-	if hitPointOnAxis < 0 || hitPointOnAxis > axisLength || t < minDist || (maxDist != -1 && t > maxDist) {
-		t = t2
-		if t < minDist || (maxDist != -1 && t > maxDist) {
-			return 0
+	// If ray is parallel to cylinder axis, no intersection with the side.
+	t := 0.0
+	a, b, c := cylinderQuadratic(rayStart, rayDir, thing)
+	if t1, t2, ok := solveQuadratic(a, b, c); ok {
+		// Keep the first intersection within the ray bounds and the cylinder height.
+		hitPointOnAxis1 := dot3(sub3(add3(rayStart, scale3(rayDir, t1)), center1), axisDir)
+		hitPointOnAxis2 := dot3(sub3(add3(rayStart, scale3(rayDir, t2)), center1), axisDir)
+		if inDistRange(t1, minDist, maxDist) && (infinite || (hitPointOnAxis1 >= 0 && hitPointOnAxis1 <= axisLength)) {
+			t = t1
+		} else if inDistRange(t2, minDist, maxDist) && (infinite || (hitPointOnAxis2 >= 0 && hitPointOnAxis2 <= axisLength)) {
+			t = t2
 		}
+	}
 
-		hitPoint = add3(rayStart, scale3(rayDir, t))
-		hitPointOnAxis = dot3(sub3(hitPoint, center1), axisDir)
-
-		if hitPointOnAxis < 0 || hitPointOnAxis > axisLength {
-			return 0
-		}
+	if capped && !infinite {
+		t = closestHit(t, discDistance(rayStart, rayDir, center1, axisDir, radius, minDist, maxDist))
+		t = closestHit(t, discDistance(rayStart, rayDir, center2, axisDir, radius, minDist, maxDist))
 	}
 
 	return t
-
 }
@@ -0,0 +1,50 @@
+package main
+
+// d[0].x = type - disc
+// d[0].y = materialIdx
+// d[0].z = radius
+// d[1].xyz = center
+// d[2].xyz = normal, normalized
+// Same parameters as the disc lights.
+func newDisc(center, normal vec3, radius float, materialIdx int) mat4 {
+	return newMat4(
+		newVec4(DiscType, float(materialIdx), radius, 0),
+		newVec4(center.x, center.y, center.z, 0),
+		newVec4(normal.x, normal.y, normal.z, 0),
+		newVec4(0, 0, 0, 0),
+	)
+}
+
+func getDisc(in mat4) (center, normal vec3, radius float) {
+	return in[1].xyz, in[2].xyz, in[0].z
+}
+
+func diffuseDisc(thing mat4, pos vec3, materials MaterialsT) vec4 {
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
+
+func normalDisc(thing mat4, pos vec3) vec3 {
+	_ = pos
+	_, normal, _ := getDisc(thing)
+	return normal
+}
+
+// uvDisc returns the coordinates of the point in the square around the disc, along the tangents of orthonormalBasis.
+func uvDisc(thing mat4, pos vec3) vec2 {
+	center, normal, radius := getDisc(thing)
+	tangent, bitangent := orthonormalBasis(normal)
+	p := sub3(pos, center)
+	return newVec2(0.5+dot3(p, tangent)/(2*radius), 0.5+dot3(p, bitangent)/(2*radius))
+}
+
+func tangentsDisc(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	_ = pos
+	_, normal, _ := getDisc(thing)
+	return orthonormalBasis(normal)
+}
+
+func hitDisc(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	center, normal, radius := getDisc(thing)
+	return discDistance(rayStart, rayDir, center, normal, radius, minDist, maxDist)
+}
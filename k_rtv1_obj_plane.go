@@ -2,49 +2,49 @@ package main
 
 // type: p[0].x - plane
 // materialIdx: p[0].y
-// isCheckerBoard: p[0].z
-// checkerSize: p[0].w
 // center: p[1].xyz
 // normal: p[2].xyz
-func newPlane(center, normal vec3, isCheckerBoard bool, checkerSize float, materialIdx int) mat4 {
-	isCheckerBoardFloat := 0.0
-	if isCheckerBoard {
-		isCheckerBoardFloat = 1.0
-	}
+func newPlane(center, normal vec3, materialIdx int) mat4 {
 	return newMat4(
-		newVec4(PlaneType, float(materialIdx), isCheckerBoardFloat, checkerSize),
-		newVec4(center.x, center.y, center.z, isCheckerBoardFloat),
-		newVec4(normal.x, normal.y, normal.z, checkerSize),
+		newVec4(PlaneType, float(materialIdx), 0, 0),
+		newVec4(center.x, center.y, center.z, 0),
+		newVec4(normal.x, normal.y, normal.z, 0),
 		newVec4(0, 0, 0, 0),
 	)
 }
 
-func getPlane(in mat4) (center, normal vec3, isCheckerBoard bool, checkerSize float) {
-	return in[1].xyz, in[2].xyz, in[0].z != 0.0, in[0].w
+func getPlane(in mat4) (center, normal vec3) {
+	return in[1].xyz, in[2].xyz
 }
 
 func diffusePlane(thing mat4, pos vec3, materials MaterialsT) vec4 {
-	_, _, isCheckerboard, checkerSize := getPlane(thing) //nolint:dogsled // Expected.
-	color := getMaterialColor(materials, getThingMaterialIdx(thing))
-	if !isCheckerboard {
-		return color
-	}
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
 
-	if (int(floor(pos.z/checkerSize))+int(floor(pos.x/checkerSize)))%2 != 0 {
-		return newVec4(0.1, 0.1, 0.1, 1)
-	}
-	return color
+// uvPlane returns the coordinates of the point in the plane, along the tangents of orthonormalBasis, in scene units.
+func uvPlane(thing mat4, pos vec3) vec2 {
+	center, normal := getPlane(thing)
+	tangent, bitangent := orthonormalBasis(normal)
+	p := sub3(pos, center)
+	return newVec2(dot3(p, tangent), dot3(p, bitangent))
+}
+
+func tangentsPlane(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	_ = pos
+	_, normal := getPlane(thing)
+	return orthonormalBasis(normal)
 }
 
 func normalPlane(thing mat4, pos vec3) vec3 {
 	_ = pos
-	_, pNorm, _, _ := getPlane(thing) //nolint:dogsled // Expected.
+	_, pNorm := getPlane(thing)
 	//log.Println(pNorm)
 	return pNorm
 }
 
 func hitPlane(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
-	pPos, pNorm, _, _ := getPlane(thing) //nolint:dogsled // Expected.
+	pPos, pNorm := getPlane(thing)
 
 	denom := dot3(rayDir, pNorm)
 	if abs(denom) < 1e-6 {
@@ -0,0 +1,76 @@
+package main
+
+// q[0].x = type - quad
+// q[0].y = materialIdx
+// q[1].xyz = center
+// q[2].xyz = first half edge
+// q[3].xyz = second half edge
+// Same parameters as the rect lights, the corners are center +/- edge1 +/- edge2.
+func newQuad(center, edge1, edge2 vec3, materialIdx int) mat4 {
+	return newMat4(
+		newVec4(QuadType, float(materialIdx), 0, 0),
+		newVec4(center.x, center.y, center.z, 0),
+		newVec4(edge1.x, edge1.y, edge1.z, 0),
+		newVec4(edge2.x, edge2.y, edge2.z, 0),
+	)
+}
+
+func getQuad(in mat4) (center, edge1, edge2 vec3) {
+	return in[1].xyz, in[2].xyz, in[3].xyz
+}
+
+// quadPoint returns the point of the quad at the given coordinates, the inverse of uvQuad.
+func quadPoint(center, edge1, edge2 vec3, uv vec2) vec3 {
+	return add3(center, add3(scale3(edge1, 2*uv.x-1), scale3(edge2, 2*uv.y-1)))
+}
+
+// quadCoords returns the coordinates of the point of the quad plane along the half edges, -1..1 inside the quad.
+// The edges don't have to be perpendicular, the quad is then a parallelogram.
+func quadCoords(thing mat4, pos vec3) vec2 {
+	center, edge1, edge2 := getQuad(thing)
+	p := sub3(pos, center)
+	n := cross3(edge1, edge2)
+	nn := dot3(n, n)
+	return newVec2(dot3(cross3(p, edge2), n)/nn, dot3(cross3(edge1, p), n)/nn)
+}
+
+func diffuseQuad(thing mat4, pos vec3, materials MaterialsT) vec4 {
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
+
+func normalQuad(thing mat4, pos vec3) vec3 {
+	_ = pos
+	_, edge1, edge2 := getQuad(thing)
+	return normalize3(cross3(edge1, edge2))
+}
+
+func uvQuad(thing mat4, pos vec3) vec2 {
+	c := quadCoords(thing, pos)
+	return newVec2((c.x+1)/2, (c.y+1)/2)
+}
+
+func tangentsQuad(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	_ = pos
+	_, edge1, edge2 := getQuad(thing)
+	return edge1, edge2
+}
+
+func hitQuad(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	center, edge1, edge2 := getQuad(thing)
+	normal := normalize3(cross3(edge1, edge2))
+
+	denom := dot3(rayDir, normal)
+	if abs(denom) < 1e-8 {
+		return 0
+	}
+	t := dot3(sub3(center, rayStart), normal) / denom
+	if !inDistRange(t, minDist, maxDist) {
+		return 0
+	}
+	c := quadCoords(thing, add3(rayStart, scale3(rayDir, t)))
+	if abs(c.x) > 1 || abs(c.y) > 1 {
+		return 0
+	}
+	return t
+}
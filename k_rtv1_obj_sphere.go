@@ -15,8 +15,7 @@ func newSphere(center vec3, radius float, materialIdx int) mat4 {
 }
 
 func getSphere(in mat4) (center vec3, radius, radius2 float) {
-	return newVec3(in[1].x, in[0].y+(cos(Time)), in[0].z), in[0].z, in[0].w
-	//return in[1].xyz, in[0].z, in[0].w
+	return in[1].xyz, in[0].z, in[0].w
 }
 
 func diffuseSphere(thing mat4, pos vec3, materials MaterialsT) vec4 {
@@ -24,27 +23,43 @@ func diffuseSphere(thing mat4, pos vec3, materials MaterialsT) vec4 {
 	return getMaterialColor(materials, getThingMaterialIdx(thing))
 }
 
-func hitSphere(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+// uvSphere returns the longitude and latitude of the point, from 0 to 1, v = 0 at the bottom.
+func uvSphere(thing mat4, pos vec3) vec2 {
+	center, radius, _ := getSphere(thing)
+	d := scale3(sub3(pos, center), 1/radius)
+	u := 0.5 + atan2(d.z, d.x)/(2*pi)
+	v := 0.5 + asin(min(1, max(-1, d.y)))/pi
+	return newVec2(u, v)
+}
+
+// tangentsSphere returns the directions of increasing u and v, along the parallel and the meridian.
+func tangentsSphere(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	center, _, _ := getSphere(thing)
+	d := sub3(pos, center)
+	return newVec3(-d.z, 0, d.x), newVec3(-d.y*d.x, d.x*d.x+d.z*d.z, -d.y*d.z)
+}
+
+// sphereRoots returns both distances where the ray line crosses the sphere, t1 <= t2, ok is false when it misses.
+func sphereRoots(rayStart, rayDir vec3, thing mat4) (t1, t2 float, ok bool) {
 	sphereCenter, _, sphereRadius2 := getSphere(thing)
 
 	oc := sub3(rayStart, sphereCenter)
 	a := dot3(rayDir, rayDir)
 	b := 2.0 * dot3(oc, rayDir)
 	c := dot3(oc, oc) - sphereRadius2
-	discriminant := b*b - 4*a*c
+	return solveQuadratic(a, b, c)
+}
 
-	if discriminant < 0 {
+func hitSphere(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	t1, t2, ok := sphereRoots(rayStart, rayDir, thing)
+	if !ok {
 		return 0
 	}
 
-	sqrtd := sqrt(discriminant)
-	root := (-b - sqrtd) / (2 * a)
-	if root < minDist || (maxDist != -1 && root > maxDist) {
-		root = (-b + sqrtd) / (2 * a)
-		if root < minDist || (maxDist != -1 && root > maxDist) {
-			return 0
-		}
+	if inDistRange(t1, minDist, maxDist) {
+		return t1
+	} else if inDistRange(t2, minDist, maxDist) {
+		return t2
 	}
-
-	return root
+	return 0
 }
@@ -0,0 +1,99 @@
+package main
+
+// t[0].x = type - torus
+// t[0].y = materialIdx
+// t[0].z = major radius, from the center to the middle of the tube
+// t[0].w = minor radius, of the tube
+// t[1].xyz = center
+// t[2].xyz = axis, normalized
+func newTorus(center, axis vec3, majorRadius, minorRadius float, materialIdx int) mat4 {
+	return newMat4(
+		newVec4(TorusType, float(materialIdx), majorRadius, minorRadius),
+		newVec4(center.x, center.y, center.z, 0),
+		newVec4(axis.x, axis.y, axis.z, 0),
+		newVec4(0, 0, 0, 0),
+	)
+}
+
+func getTorus(in mat4) (center, axis vec3, majorRadius, minorRadius float) {
+	return in[1].xyz, in[2].xyz, in[0].z, in[0].w
+}
+
+func diffuseTorus(thing mat4, pos vec3, materials MaterialsT) vec4 {
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
+
+// uvTorus returns the angle around the axis and the angle around the tube, from 0 to 1.
+func uvTorus(thing mat4, pos vec3) vec2 {
+	center, axis, majorRadius, _ := getTorus(thing)
+	p := sub3(pos, center)
+	height := dot3(p, axis)
+	radial := length3(sub3(p, scale3(axis, height))) - majorRadius
+	return newVec2(angleAround(p, axis), 0.5+atan2(height, radial)/(2*pi))
+}
+
+// tangentsTorus returns the directions of increasing u and v, around the axis and around the tube.
+func tangentsTorus(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	center, axis, majorRadius, _ := getTorus(thing)
+	p := sub3(pos, center)
+	height := dot3(p, axis)
+	perp := sub3(p, scale3(axis, height))
+	radial := length3(perp) - majorRadius
+	return angleTangent(p, axis), add3(scale3(normalize3(perp), -height), scale3(axis, radial))
+}
+
+// normalTorus returns the gradient of (|p|^2 + R^2 - r^2)^2 - 4R^2 |p - (p.axis) axis|^2, p relative to the center.
+func normalTorus(thing mat4, pos vec3) vec3 {
+	center, axis, majorRadius, minorRadius := getTorus(thing)
+
+	p := sub3(pos, center)
+	perp := sub3(p, scale3(axis, dot3(p, axis)))
+	k := dot3(p, p) + majorRadius*majorRadius - minorRadius*minorRadius
+	return normalize3(sub3(scale3(p, k), scale3(perp, 2*majorRadius*majorRadius)))
+}
+
+func hitTorus(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	center, axis, majorRadius, minorRadius := getTorus(thing)
+	R2, r2 := majorRadius*majorRadius, minorRadius*minorRadius
+
+	// The quartic is solved with a unit direction, the distances are scaled back at the end.
+	dirLength := length3(rayDir)
+	d := scale3(rayDir, 1/dirLength)
+	oc := sub3(rayStart, center)
+
+	// Only the part of the ray within the bounding sphere is searched.
+	boundRadius := majorRadius + minorRadius
+	sIn, sOut, ok := solveQuadratic(1, 2*dot3(oc, d), dot3(oc, oc)-boundRadius*boundRadius)
+	if !ok {
+		return 0
+	}
+	lo, hi := max(sIn, minDist*dirLength), sOut
+	if maxDist != -1 {
+		hi = min(hi, maxDist*dirLength)
+	}
+	if lo > hi {
+		return 0
+	}
+
+	// Start the ray from the bounding sphere, the coefficients stay small, which keeps the float32 precision.
+	// In the torus frame, the axis is z.
+	o := add3(oc, scale3(d, lo))
+	u, v := orthonormalBasis(axis)
+	ol := newVec3(dot3(o, u), dot3(o, v), dot3(o, axis))
+	dl := newVec3(dot3(d, u), dot3(d, v), dot3(d, axis))
+
+	// (|p|^2 + R^2 - r^2)^2 = 4R^2 (px^2 + py^2), with p = ol + t*dl.
+	k := dot3(ol, dl)
+	m := dot3(ol, ol) + R2 - r2
+	a := 4 * k
+	b := 4*k*k + 2*m - 4*R2*(dl.x*dl.x+dl.y*dl.y)
+	c := 4*k*m - 8*R2*(ol.x*dl.x+ol.y*dl.y)
+	e := m*m - 4*R2*(ol.x*ol.x+ol.y*ol.y)
+
+	root, found := firstQuarticRoot(a, b, c, e, 0, hi-lo)
+	if !found {
+		return 0
+	}
+	return (lo + root) / dirLength
+}
@@ -0,0 +1,119 @@
+package main
+
+// t[0].x = type - triangle
+// t[0].y = materialIdx
+// t[0].z = hasNormals, when set, the next thing holds the vertex normals
+// t[1].xyz = v0
+// t[2].xyz = v1
+// t[3].xyz = v2
+func newTriangle(v0, v1, v2 vec3, hasNormals bool, materialIdx int) mat4 {
+	hasNormalsFloat := 0.0
+	if hasNormals {
+		hasNormalsFloat = 1.0
+	}
+	return newMat4(
+		newVec4(TriangleType, float(materialIdx), hasNormalsFloat, 0),
+		newVec4(v0.x, v0.y, v0.z, 0),
+		newVec4(v1.x, v1.y, v1.z, 0),
+		newVec4(v2.x, v2.y, v2.z, 0),
+	)
+}
+
+// n[0].x = type - triangle normals, never hit, only read from the triangle before it
+// n[1].xyz = normal at v0
+// n[2].xyz = normal at v1
+// n[3].xyz = normal at v2
+func newTriangleNormals(n0, n1, n2 vec3) mat4 {
+	return newMat4(
+		newVec4(TriangleNormalsType, 0, 0, 0),
+		newVec4(n0.x, n0.y, n0.z, 0),
+		newVec4(n1.x, n1.y, n1.z, 0),
+		newVec4(n2.x, n2.y, n2.z, 0),
+	)
+}
+
+func getTriangle(in mat4) (v0, v1, v2 vec3, hasNormals bool) {
+	return in[1].xyz, in[2].xyz, in[3].xyz, in[0].z != 0.0
+}
+
+func diffuseTriangle(thing mat4, pos vec3, materials MaterialsT) vec4 {
+	_ = pos
+	return getMaterialColor(materials, getThingMaterialIdx(thing))
+}
+
+// barycentric returns the barycentric coordinates of the point of the triangle, the weights of v0, v1 and v2.
+func barycentric(thing mat4, pos vec3) (w0, w1, w2 float) {
+	v0, v1, v2, _ := getTriangle(thing)
+	edge1 := sub3(v1, v0)
+	edge2 := sub3(v2, v0)
+	ep := sub3(pos, v0)
+	d00 := dot3(edge1, edge1)
+	d01 := dot3(edge1, edge2)
+	d11 := dot3(edge2, edge2)
+	d20 := dot3(ep, edge1)
+	d21 := dot3(ep, edge2)
+	denom := d00*d11 - d01*d01
+	w1 = (d11*d20 - d01*d21) / denom
+	w2 = (d00*d21 - d01*d20) / denom
+	w0 = 1.0 - w1 - w2
+	return w0, w1, w2
+}
+
+// uvTriangle returns the weights of v1 and v2, the triangles having no texture coordinates of their own.
+func uvTriangle(thing mat4, pos vec3) vec2 {
+	_, w1, w2 := barycentric(thing, pos)
+	return newVec2(w1, w2)
+}
+
+func tangentsTriangle(thing mat4, pos vec3) (tangent, bitangent vec3) {
+	_ = pos
+	v0, v1, v2, _ := getTriangle(thing)
+	return sub3(v1, v0), sub3(v2, v0)
+}
+
+// normalTriangle returns the normal at the given point of the triangle.
+// When the triangle has vertex normals, they are interpolated for smooth shading, otherwise the face normal is used.
+func normalTriangle(thing, normals mat4, pos vec3) vec3 {
+	v0, v1, v2, hasNormals := getTriangle(thing)
+	if !hasNormals || getThingType(normals) != TriangleNormalsType {
+		return normalize3(cross3(sub3(v1, v0), sub3(v2, v0)))
+	}
+
+	w0, w1, w2 := barycentric(thing, pos)
+	normal := scale3(normals[1].xyz, w0)
+	normal = add3(normal, scale3(normals[2].xyz, w1))
+	normal = add3(normal, scale3(normals[3].xyz, w2))
+	return normalize3(normal)
+}
+
+// hitTriangle implements the Möller–Trumbore intersection.
+func hitTriangle(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float {
+	v0, v1, v2, _ := getTriangle(thing)
+
+	edge1 := sub3(v1, v0)
+	edge2 := sub3(v2, v0)
+	h := cross3(rayDir, edge2)
+	a := dot3(edge1, h)
+	if abs(a) < 1e-8 { // Ray parallel to the triangle.
+		return 0
+	}
+
+	f := 1.0 / a
+	s := sub3(rayStart, v0)
+	u := f * dot3(s, h)
+	if u < 0 || u > 1 {
+		return 0
+	}
+	q := cross3(s, edge1)
+	v := f * dot3(rayDir, q)
+	if v < 0 || u+v > 1 {
+		return 0
+	}
+
+	dist := f * dot3(edge2, q)
+	if dist < minDist || (maxDist != -1 && dist > maxDist) {
+		return 0
+	}
+
+	return dist
+}
@@ -0,0 +1,31 @@
+package main
+
+// This file holds the patterns of the materials, alternating with a second material
+// in the UV coordinates of the thing.
+
+const (
+	CheckerPattern = 1
+	StripesPattern = 2
+	GridPattern    = 3
+	// WorldCheckerPattern is a checker in the world X/Z coordinates instead of the UV ones,
+	// for the legacy is_checkerboard planes.
+	WorldCheckerPattern = 4
+)
+
+// gridLineWidth is the width of the grid lines, relative to the size of the cells.
+const gridLineWidth = 0.1
+
+// patternAlternate returns true if the pattern shows its second material at the given UV coordinates.
+// The parity is computed with floats, the int modulo is undefined for negative values in the shader.
+func patternAlternate(pType int, size float, uv vec2) bool {
+	u, v := uv.x/size, uv.y/size
+	if pType == CheckerPattern || pType == WorldCheckerPattern {
+		cell := floor(u) + floor(v)
+		return cell-2*floor(cell/2) != 0
+	} else if pType == StripesPattern {
+		return floor(u)-2*floor(u/2) != 0
+	} else if pType == GridPattern {
+		return u-floor(u) < gridLineWidth || v-floor(v) < gridLineWidth
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+// This file holds the physically based material model: a Lambert diffuse and a Cook-Torrance GGX specular,
+// driven by the base color, the metallic and the roughness of the material.
+
+// PBRModel is the specular power of the physically based materials, the Phong ones having a positive one.
+const PBRModel = -1
+
+// dielectricReflectance is the reflectance at normal incidence of the non metallic surfaces.
+const dielectricReflectance = 0.04
+
+// getMaterialPBR returns whether the material uses the physically based model, with its metallic and roughness.
+func getMaterialPBR(materials MaterialsT, idx int) (pbr bool, metallic, roughness float) {
+	m := materials[idx]
+	return m[2].x == PBRModel, m[0].z, m[0].w
+}
+
+// specularColor returns the reflectance at normal incidence: grey for the dielectrics, the base color for the metals.
+func specularColor(baseColor vec4, metallic float) vec4 {
+	return add4(scale4(newVec4(dielectricReflectance, dielectricReflectance, dielectricReflectance, 1), 1-metallic), scale4(baseColor, metallic))
+}
+
+// fresnelSchlick returns the reflectance for the given cosine of the incident angle.
+func fresnelSchlick(f0 vec4, cosI float) vec4 {
+	x := 1 - max(0, min(1, cosI))
+	f := x * x * x * x * x
+	return add4(scale4(f0, 1-f), scale4(newVec4(1, 1, 1, 1), f))
+}
+
+// cookTorrance returns the light reflected toward the viewer for a unit light coming from lightDir, cosine included.
+// The result is multiplied by pi, like the light intensities, so a white diffuse surface facing the light
+// gets the same brightness as a Phong one with a diffuse of 1.
+// diffuseWeight scales the diffuse part, e.g. for the transparent materials.
+func cookTorrance(baseColor vec4, normal, viewDir, lightDir vec3, metallic, roughness, diffuseWeight float) vec4 {
+	nDotL := dot3(normal, lightDir)
+	nDotV := dot3(normal, viewDir)
+	if nDotL <= 0 || nDotV <= 0 {
+		return newVec4(0, 0, 0, 1)
+	}
+	halfway := normalize3(add3(viewDir, lightDir))
+	nDotH := max(0, dot3(normal, halfway))
+
+	// GGX normal distribution.
+	alpha := max(0.001, roughness*roughness)
+	alpha2 := alpha * alpha
+	d := nDotH*nDotH*(alpha2-1) + 1
+	distribution := alpha2 / (pi * d * d)
+
+	// Smith geometry term, with the Schlick-GGX approximation.
+	k := (roughness + 1) * (roughness + 1) / 8
+	geometry := nDotL / (nDotL*(1-k) + k) * nDotV / (nDotV*(1-k) + k)
+
+	fresnel := fresnelSchlick(specularColor(baseColor, metallic), dot3(viewDir, halfway))
+	specular := scale4(fresnel, distribution*geometry/(4*nDotL*nDotV))
+
+	// The light not reflected is diffused, the metals don't diffuse.
+	kd := scale4(add4(newVec4(1, 1, 1, 1), scale4(fresnel, -1)), (1-metallic)*diffuseWeight)
+	diffuse := mul4(kd, baseColor)
+
+	return scale4(add4(diffuse, scale4(specular, pi)), nDotL)
+}
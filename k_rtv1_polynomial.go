@@ -0,0 +1,121 @@
+package main
+
+// This file holds the polynomial solvers used by the intersections.
+
+// solveQuadratic returns the roots of a*t^2 + b*t + c, t1 <= t2, ok is false when there are none.
+// A tiny a is considered zero, which is not a quadratic equation, so ok is false as well.
+func solveQuadratic(a, b, c float) (t1, t2 float, ok bool) {
+	if abs(a) < 1e-8 {
+		return 0, 0, false
+	}
+	discriminant := b*b - 4.0*a*c
+	if discriminant < 0.0 {
+		return 0, 0, false
+	}
+	sqrtd := sqrt(discriminant)
+	t1 = (-b - sqrtd) / (2.0 * a)
+	t2 = (-b + sqrtd) / (2.0 * a)
+	if t1 > t2 {
+		t1, t2 = t2, t1
+	}
+	return t1, t2, true
+}
+
+// polyIterations is the max number of refinement steps of a root.
+const polyIterations = 32
+
+// evalPoly returns q4*x^4 + q.x*x^3 + q.y*x^2 + q.z*x + q.w, the cubics having q4 = 0.
+func evalPoly(q4 float, q vec4, x float) float {
+	return (((q4*x+q.x)*x+q.y)*x+q.z)*x + q.w
+}
+
+// evalPolyDerivative returns the derivative of the evalPoly polynomial at x.
+func evalPolyDerivative(q4 float, q vec4, x float) float {
+	return ((4*q4*x+3*q.x)*x+2*q.y)*x + q.z
+}
+
+// refineRoot returns the root of the polynomial between lo and hi, where it changes sign.
+// Newton's method converges fast, the bisection of the bracket keeps it from diverging.
+func refineRoot(q4 float, q vec4, lo, hi float) float {
+	fLo := evalPoly(q4, q, lo)
+	x := (lo + hi) / 2
+	for i := 0; i < polyIterations; i++ {
+		fx := evalPoly(q4, q, x)
+		if fx == 0 {
+			return x
+		}
+		if (fx < 0) == (fLo < 0) {
+			lo, fLo = x, fx
+		} else {
+			hi = x
+		}
+		next := x - fx/evalPolyDerivative(q4, q, x)
+		if !(next > lo && next < hi) { // Also catches the division by zero.
+			next = (lo + hi) / 2
+		}
+		if next == x {
+			break
+		}
+		x = next
+	}
+	return x
+}
+
+// firstQuarticRoot returns the smallest root of x^4 + a*x^3 + b*x^2 + c*x + d between lo and hi,
+// ok is false when there is none.
+// Rather than the closed form, unstable with float32, the roots are isolated between the extrema of the polynomial,
+// which are the roots of its derivative, themselves isolated by the roots of the second derivative.
+// Each part between two extrema is monotonic, it has a root if the polynomial changes sign.
+// A double root, where the polynomial only touches zero, is missed, it is a tangent ray.
+func firstQuarticRoot(a, b, c, d, lo, hi float) (root float, ok bool) {
+	quartic := newVec4(a, b, c, d)
+	cubic := newVec4(4, 3*a, 2*b, c) // Derivative.
+
+	// Extrema of the derivative, the roots of the second derivative.
+	var cubicBounds [4]float
+	cubicBounds[0] = lo
+	n := 1
+	e1, e2, eOk := solveQuadratic(12, 6*a, 2*b)
+	if eOk && e1 > lo && e1 < hi {
+		cubicBounds[n] = e1
+		n++
+	}
+	if eOk && e2 > lo && e2 < hi {
+		cubicBounds[n] = e2
+		n++
+	}
+	cubicBounds[n] = hi
+	n++
+
+	// Extrema of the quartic, the roots of its derivative.
+	var bounds [5]float
+	bounds[0] = lo
+	m := 1
+	for i := 0; i < 3; i++ {
+		if i+1 >= n {
+			break
+		}
+		l, h := cubicBounds[i], cubicBounds[i+1]
+		if evalPoly(0, cubic, l)*evalPoly(0, cubic, h) < 0 {
+			bounds[m] = refineRoot(0, cubic, l, h)
+			m++
+		}
+	}
+	bounds[m] = hi
+	m++
+
+	for i := 0; i < 4; i++ {
+		if i+1 >= m {
+			break
+		}
+		l, h := bounds[i], bounds[i+1]
+		fl := evalPoly(1, quartic, l)
+		if fl == 0 {
+			return l, true
+		}
+		if fl*evalPoly(1, quartic, h) < 0 {
+			return refineRoot(1, quartic, l, h), true
+		}
+	}
+	return 0, false
+}
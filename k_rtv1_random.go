@@ -0,0 +1,25 @@
+package main
+
+// This file holds the pseudo random generator, used for the sampling.
+// Kage has no random source, so the numbers come from hashing the pixel position and the sample indices,
+// the same pixel gets the same noise on the CPU and the GPU.
+
+// hash mixes the bits of the given int, the result is a non negative int.
+// Kage ints are 32 bits: the values are masked to 31 bits after each multiplication,
+// so the 64 bits Go ints end up with the same bits.
+func hash(x int) int {
+	x &= 0x7fffffff
+	x = ((x>>16 ^ x) * 0x45d9f3b) & 0x7fffffff
+	x = ((x>>16 ^ x) * 0x45d9f3b) & 0x7fffffff
+	return x>>16 ^ x
+}
+
+// hashSeed combines the seed with the given index.
+func hashSeed(seed, idx int) int {
+	return hash(seed ^ hash(idx))
+}
+
+// random returns a number in [0, 1) from the given seed.
+func random(seed int) float {
+	return float(hash(seed)&0xffff) / 65536
+}
@@ -9,28 +9,98 @@ func intersect(rayStart, rayDir vec3, thing mat4, minDist, maxDist float) float
 		return hitCone(rayStart, rayDir, thing, minDist, maxDist)
 	} else if t == CylinderType {
 		return hitCylinder(rayStart, rayDir, thing, minDist, maxDist)
+	} else if t == TriangleType {
+		return hitTriangle(rayStart, rayDir, thing, minDist, maxDist)
+	} else if t == TorusType {
+		return hitTorus(rayStart, rayDir, thing, minDist, maxDist)
+	} else if t == BoxType {
+		return hitBox(rayStart, rayDir, thing, minDist, maxDist)
+	} else if t == QuadType {
+		return hitQuad(rayStart, rayDir, thing, minDist, maxDist)
+	} else if t == DiscType {
+		return hitDisc(rayStart, rayDir, thing, minDist, maxDist)
 	}
-	return -1.
+	return 0 // No hit, includes the triangle normals, the transforms and the CSG, hit through intersectThing.
 }
 
-func intersection(rayStart, rayDir vec3, things ThingsT, minDist, maxDist float) (closestThing mat4, closest float) {
+// inDistRange returns whether the distance is within the ray bounds, maxDist being -1 when unbounded.
+func inDistRange(t, minDist, maxDist float) bool {
+	return t >= minDist && (maxDist == -1 || t <= maxDist)
+}
+
+// intersection returns the closest thing hit by the ray and its index, closest is 0 when nothing got hit.
+// The unbounded things are tested one by one, the others through the BVH.
+func intersection(rayStart, rayDir vec3, things ThingsT, bvh BVHT, minDist, maxDist float) (closestThing mat4, closestIdx int, closest float) {
 	closest = maxDist
 	hitSomething := false
-	for i := 0; i < len(things); i++ {
-		dist := intersect(rayStart, rayDir, things[i], minDist, closest)
-		hit := dist != 0
-		if hit {
+
+	unbounded, nodes := getBVHHeader(getBVHEntry(bvh, 0))
+	for i := 0; i < maxDataItems; i++ {
+		if i >= unbounded {
+			break
+		}
+		dist, hitIdx := intersectThing(rayStart, rayDir, things, i, minDist, closest)
+		if dist != 0 {
 			hitSomething = true
 			closest = dist
-			closestThing = things[i]
+			closestThing = getThing(things, hitIdx)
+			closestIdx = hitIdx
 		}
+	}
 
+	// Kage doesn't support recursion, the tree is traversed with a stack.
+	// Each node is pushed at most once, so the traversal is bound by the number of nodes.
+	invDir := newVec3(safeInverse(rayDir.x), safeInverse(rayDir.y), safeInverse(rayDir.z))
+	var stack [bvhStackSize]int
+	sp := 0
+	if nodes > 1 {
+		stack[0] = 1
+		sp = 1
 	}
+	for step := 0; step < maxDataItems; step++ {
+		if sp == 0 || step >= getBVHEntryCount(bvh) {
+			break
+		}
+		sp--
+		a, b, isLeaf, lo, hi := getBVHNode(getBVHEntry(bvh, stack[sp]))
+
+		limit := closest
+		if limit == -1 {
+			limit = 1e30
+		}
+		if !hitAABB(rayStart, invDir, lo, hi, minDist, limit) {
+			continue
+		}
+
+		if !isLeaf {
+			// Push the right child first so the left one is visited first.
+			if sp+2 <= bvhStackSize {
+				stack[sp] = b
+				stack[sp+1] = a
+				sp += 2
+			}
+			continue
+		}
+
+		for j := 0; j < bvhMaxLeafThings; j++ {
+			if j >= b {
+				break
+			}
+			dist, hitIdx := intersectThing(rayStart, rayDir, things, a+j, minDist, closest)
+			if dist != 0 {
+				hitSomething = true
+				closest = dist
+				closestThing = getThing(things, hitIdx)
+				closestIdx = hitIdx
+			}
+		}
+	}
+
 	if !hitSomething {
 		closest = 0.
 	}
 
-	return closestThing, closest
+	return closestThing, closestIdx, closest
 }
 
 func initRay(width, height, x, y int, cameraComponents mat4) vec3 {
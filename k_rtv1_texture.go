@@ -0,0 +1,210 @@
+package main
+
+// This file holds the textures of the materials.
+// The images are looked up with the UV coordinates of the hit point. They are packed in an atlas
+// at the top of the source image of the shader, the CPU renderer reads the same texels.
+// The procedural textures are solid, computed from the position of the hit point.
+
+const (
+	ImageTexture      = 1
+	NoiseTexture      = 2
+	TurbulenceTexture = 3
+	MarbleTexture     = 4
+	WoodTexture       = 5
+	StripesTexture    = 6
+	RingsTexture      = 7
+	GradientTexture   = 8
+)
+
+const (
+	TextureSpaceObject = 1 // Follows the transform of the object.
+	TextureSpaceWorld  = 2
+)
+
+const (
+	TextureWrapRepeat = 1
+	TextureWrapMirror = 2
+	TextureWrapClamp  = 3
+)
+
+const (
+	TextureFilterBilinear = 1
+	TextureFilterNearest  = 2
+)
+
+// t[0].xy = position of the image in the atlas, in pixels
+// t[0].zw = size of the image, in pixels
+// t[1].xy = scale of the UV coordinates
+// t[1].zw = offset of the UV coordinates, added after the scale
+// t[2].x = type - image
+// t[2].y = wrap mode
+// t[2].z = filter
+// t[3].x = strength, for the normal maps
+func newImageTexture(x, y, width, height int, scale, offset vec2, wrap, filter int, strength float) mat4 {
+	return newMat4(
+		newVec4(float(x), float(y), float(width), float(height)),
+		newVec4(scale.x, scale.y, offset.x, offset.y),
+		newVec4(ImageTexture, float(wrap), float(filter), 0),
+		newVec4(strength, 0, 0, 0),
+	)
+}
+
+// t[0] = first color
+// t[1] = second color, for the bumps the first one is 0 and the second one the strength
+// t[2].x = type
+// t[2].y = octaves of the noise
+// t[2].z = space, object or world
+// t[2].w = noise kind
+// t[3].xyz = axis of the pattern, its length is the frequency
+// t[3].w = turbulence, the amount of noise added to the pattern
+func newProceduralTexture(tType int, color1, color2 vec4, axis vec3, frequency float, octaves, space, kind int, turbulence float) mat4 {
+	a := scale3(normalize3(axis), frequency)
+	return newMat4(
+		color1,
+		color2,
+		newVec4(float(tType), float(octaves), float(space), float(kind)),
+		newVec4(a.x, a.y, a.z, turbulence),
+	)
+}
+
+// wrapTexel brings the texel coordinate within 0..size-1.
+// The modulo is done with floats, the int one is undefined for negative values in the shader.
+func wrapTexel(i, size, wrap int) int {
+	if wrap == TextureWrapClamp {
+		return min(max(i, 0), size-1)
+	}
+	if wrap == TextureWrapMirror {
+		m := i - 2*size*int(floor(float(i)/float(2*size)))
+		if m >= size {
+			return 2*size - 1 - m
+		}
+		return m
+	}
+	return i - size*int(floor(float(i)/float(size)))
+}
+
+// textureTexel returns the texel of the texture image at the given coordinates, wrapped.
+func textureTexel(textures TexturesT, texture mat4, x, y int) vec4 {
+	width, height := int(texture[0].z), int(texture[0].w)
+	wrap := int(texture[2].y)
+	return getTextureTexel(textures, int(texture[0].x)+wrapTexel(x, width, wrap), int(texture[0].y)+wrapTexel(y, height, wrap))
+}
+
+// sampleTexture returns the color of the texture at the given UV coordinates.
+// v goes up while the image rows go down, so v = 0 is the bottom of the image.
+func sampleTexture(textures TexturesT, idx int, uv vec2) vec4 {
+	texture := getTexture(textures, idx)
+	u := uv.x*texture[1].x + texture[1].z
+	v := uv.y*texture[1].y + texture[1].w
+	px := u * texture[0].z
+	py := (1 - v) * texture[0].w
+
+	if int(texture[2].z) == TextureFilterNearest {
+		return textureTexel(textures, texture, int(floor(px)), int(floor(py)))
+	}
+
+	// Bilinear, between the centers of the 4 closest texels.
+	px -= 0.5
+	py -= 0.5
+	x, y := int(floor(px)), int(floor(py))
+	fx, fy := px-floor(px), py-floor(py)
+	top := add4(scale4(textureTexel(textures, texture, x, y), 1-fx), scale4(textureTexel(textures, texture, x+1, y), fx))
+	bottom := add4(scale4(textureTexel(textures, texture, x, y+1), 1-fx), scale4(textureTexel(textures, texture, x+1, y+1), fx))
+	return add4(scale4(top, 1-fy), scale4(bottom, fy))
+}
+
+// textureColor returns the color of the texture at the hit point.
+// The images use the UV coordinates of the thing, the procedural textures the point in the object space or in the world.
+func textureColor(textures TexturesT, idx int, thing mat4, hitPoint, localPoint vec3) vec4 {
+	texture := getTexture(textures, idx)
+	if int(texture[2].x) == ImageTexture {
+		return sampleTexture(textures, idx, getThingUV(thing, localPoint))
+	}
+	if textureInWorld(textures, idx) {
+		return proceduralTexture(texture, hitPoint)
+	}
+	return proceduralTexture(texture, localPoint)
+}
+
+// textureInWorld returns true for the procedural textures in the world space.
+func textureInWorld(textures TexturesT, idx int) bool {
+	texture := getTexture(textures, idx)
+	return int(texture[2].x) != ImageTexture && int(texture[2].z) == TextureSpaceWorld
+}
+
+// proceduralTexture returns the color of the procedural texture at the given point,
+// blending its two colors with the pattern value, from 0 to 1.
+func proceduralTexture(texture mat4, pos vec3) vec4 {
+	tType, octaves, kind := int(texture[2].x), int(texture[2].y), int(texture[2].w)
+	axis := texture[3].xyz
+	frequency := length3(axis)
+	p := scale3(pos, frequency)
+
+	// Noise shifting the pattern, for the marble veins or the wood grain.
+	perturbation := 0.0
+	if texture[3].w != 0 {
+		perturbation = texture[3].w * turbulence(p, octaves, kind)
+	}
+	// Position along the axis, and distance to the axis going through the origin, in periods.
+	along := dot3(pos, axis) + perturbation
+	around := length3(sub3(p, scale3(axis, dot3(p, axis)/(frequency*frequency)))) + perturbation
+
+	t := 0.0
+	if tType == NoiseTexture {
+		t = 0.5 + 0.5*fractalNoise(p, octaves, kind)
+	} else if tType == TurbulenceTexture {
+		t = turbulence(p, octaves, kind)
+	} else if tType == MarbleTexture {
+		t = 0.5 + 0.5*sin(2*pi*along)
+	} else if tType == WoodTexture {
+		t = around - floor(around)
+	} else if tType == StripesTexture {
+		if along-floor(along) >= 0.5 {
+			t = 1
+		}
+	} else if tType == RingsTexture {
+		if around-floor(around) >= 0.5 {
+			t = 1
+		}
+	} else if tType == GradientTexture {
+		t = min(1, max(0, along))
+	}
+	return add4(scale4(texture[0], 1-t), scale4(texture[1], t))
+}
+
+// getThingUV returns the texture coordinates of the given point of the thing, in its object space.
+func getThingUV(thing mat4, pos vec3) vec2 {
+	if t := getThingType(thing); t == SphereType {
+		return uvSphere(thing, pos)
+	} else if t == PlaneType {
+		return uvPlane(thing, pos)
+	} else if t == ConeType {
+		return uvCone(thing, pos)
+	} else if t == CylinderType {
+		return uvCylinder(thing, pos)
+	} else if t == TriangleType {
+		return uvTriangle(thing, pos)
+	} else if t == TorusType {
+		return uvTorus(thing, pos)
+	} else if t == BoxType {
+		return uvBox(thing, pos)
+	} else if t == QuadType {
+		return uvQuad(thing, pos)
+	} else if t == DiscType {
+		return uvDisc(thing, pos)
+	}
+	return newVec2(0, 0)
+}
+
+// angleAround returns the angle of the point around the axis, from 0 to 1 for a full turn,
+// starting from the tangent of orthonormalBasis.
+func angleAround(p, axisDir vec3) float {
+	tangent, bitangent := orthonormalBasis(axisDir)
+	return 0.5 + atan2(dot3(p, bitangent), dot3(p, tangent))/(2*pi)
+}
+
+// angleTangent returns the direction of increasing angleAround at the point.
+func angleTangent(p, axisDir vec3) vec3 {
+	tangent, bitangent := orthonormalBasis(axisDir)
+	return add3(scale3(tangent, -dot3(p, bitangent)), scale3(bitangent, dot3(p, tangent)))
+}
@@ -0,0 +1,324 @@
+package main
+
+// This file contains the tracing logic of the raytracer.
+// It compiles to both Go and Kage shader (after pre-processing).
+
+// rayStackSize is the size of the stack of pending rays in trace.
+// The rays are traced depth first, so the stack never holds more than one ray per bounce plus one.
+const rayStackSize = maxDepth + 2
+
+// maxTraceRays is the max number of rays traced per pixel, reflections and refractions included.
+// The shader loops need a constant bound, so it caps the ray tree whatever the depth: the rays still pending
+// when it is reached are dropped. Things both transparent and reflective can reach it from depth 5.
+const maxTraceRays = 32
+
+// maxShadowHits is the max number of transparent things a shadow ray goes through.
+const maxShadowHits = 8
+
+// trace follows the ray and its reflected and refracted rays, up to depth bounces.
+// Each ray contributes to the final color weighted by the reflective index, transparency and tint of the previous hits.
+// Kage doesn't support recursion, so the pending rays are kept in a stack.
+// seed is the pixel's random seed.
+func trace(rayStart vec3, rayDir vec3, lights LightsT, things ThingsT, bvh BVHT, materials MaterialsT, textures TexturesT, ambientLight mat4, depth, seed int) vec4 {
+	result := newVec4(0, 0, 0, 1)
+
+	var stackStart [rayStackSize]vec3
+	var stackDir [rayStackSize]vec3
+	var stackWeight [rayStackSize]vec4
+	var stackBounce [rayStackSize]int
+	stackStart[0] = rayStart
+	stackDir[0] = rayDir
+	stackWeight[0] = newVec4(1, 1, 1, 1)
+	stackBounce[0] = 0
+	sp := 1
+
+	for i := 0; i < maxTraceRays; i++ {
+		if sp == 0 {
+			break
+		}
+		sp--
+		start, dir, weight, bounce := stackStart[sp], stackDir[sp], stackWeight[sp], stackBounce[sp]
+
+		color, hitPoint, hitNormal, inside, matIdx, hit := shade(start, dir, lights, things, bvh, materials, textures, ambientLight, hashSeed(seed, i))
+		result = add4(result, mul4(color, weight))
+
+		// Stop when the ray escaped the scene or the max depth is reached.
+		if !hit || bounce >= depth {
+			continue
+		}
+
+		matColor, _, _, _, _, reflectiveIndex := getMaterial(materials, matIdx)
+		transparency, refractiveIndex := getMaterialTransparency(materials, matIdx)
+
+		// Split the transmitted light between reflection and refraction with the Fresnel factor.
+		reflectWeight := reflectiveIndex
+		if transparency > 0 {
+			eta := 1.0 / refractiveIndex
+			if inside {
+				eta = refractiveIndex
+			}
+			refracted := refract3(dir, hitNormal, eta)
+			kr := 1.0 // Total internal reflection.
+			if length3(refracted) > 0 {
+				kr = schlick(-dot3(dir, hitNormal), eta)
+			}
+			reflectWeight = min(1, reflectWeight+transparency*kr)
+
+			// The refracted light is tinted by the material color.
+			refractWeight := scale4(mul4(weight, matColor), transparency*(1-kr))
+			if kr < 1 && maxComponent4(refractWeight) >= minContribution && sp < rayStackSize {
+				stackStart[sp] = hitPoint
+				stackDir[sp] = normalize3(refracted)
+				stackWeight[sp] = refractWeight
+				stackBounce[sp] = bounce + 1
+				sp++
+			}
+		}
+
+		reflectedWeight := scale4(weight, reflectWeight)
+		if pbr, metallic, roughness := getMaterialPBR(materials, matIdx); pbr {
+			// The smooth surfaces mirror the scene, tinted by their reflectance.
+			smoothness := (1 - roughness) * (1 - roughness) * (1 - transparency)
+			reflectance := fresnelSchlick(specularColor(matColor, metallic), -dot3(dir, hitNormal))
+			reflectedWeight = add4(reflectedWeight, scale4(mul4(weight, reflectance), smoothness))
+		}
+		if maxComponent4(reflectedWeight) >= minContribution && sp < rayStackSize {
+			stackStart[sp] = hitPoint
+			stackDir[sp] = reflect3(dir, hitNormal)
+			stackWeight[sp] = reflectedWeight
+			stackBounce[sp] = bounce + 1
+			sp++
+		}
+	}
+
+	return result
+}
+
+// schlick returns the Schlick approximation of the Fresnel reflectance.
+// cosI is the cosine of the incident angle, eta the ratio of the refractive indices.
+func schlick(cosI, eta float) float {
+	r0 := (1 - eta) / (1 + eta)
+	r0 *= r0
+	c := cosI
+	// Going to a less dense medium, use the transmitted angle.
+	if eta > 1 {
+		sin2T := eta * eta * (1 - cosI*cosI)
+		if sin2T > 1 {
+			return 1
+		}
+		c = sqrt(1 - sin2T)
+	}
+	x := 1 - c
+	return r0 + (1-r0)*x*x*x*x*x
+}
+
+func maxComponent4(v vec4) float {
+	return max(v.x, max(v.y, v.z))
+}
+
+// shadowFilter returns the fraction of the light reaching the hit point, per color component.
+// Opaque things block the light, transparent ones let it through, tinted by their color.
+// lightDistance is -1 for the lights infinitely far.
+func shadowFilter(hitPoint, lightDir vec3, lightDistance float, things ThingsT, bvh BVHT, materials MaterialsT) vec4 {
+	filter := newVec4(1, 1, 1, 1)
+	start := hitPoint
+	remaining := lightDistance
+	for i := 0; i < maxShadowHits; i++ {
+		thing, _, dist := intersection(start, lightDir, things, bvh, 0.001, remaining)
+		if dist == 0 {
+			return filter
+		}
+		color, _, _, _, _, _ := getMaterial(materials, getThingMaterialIdx(thing))
+		transparency, _ := getMaterialTransparency(materials, getThingMaterialIdx(thing))
+		filter = scale4(mul4(filter, color), transparency)
+		if transparency == 0 || maxComponent4(filter) < minContribution {
+			return newVec4(0, 0, 0, 1)
+		}
+		start = add3(start, scale3(lightDir, dist))
+		if remaining != -1 {
+			remaining -= dist
+		}
+	}
+	return newVec4(0, 0, 0, 1) // Too many things in the way.
+}
+
+// shade computes the color seen by the ray at its closest hit.
+// Returns the hit point, the normal facing the ray, whether the ray comes from inside the thing
+// and its material for the next bounces, hit is false when nothing got hit.
+// seed drives the sampling of the area lights.
+func shade(rayStart vec3, rayDir vec3, lights LightsT, things ThingsT, bvh BVHT, materials MaterialsT, textures TexturesT, ambientLight mat4, seed int) (result vec4, hitPoint, hitNormal vec3, inside bool, matIdx int, hit bool) {
+	result = newVec4(0.1, 0.1, 0.1, 1) // Background color.
+	closestThing, closestIdx, dist := intersection(rayStart, rayDir, things, bvh, 0.001, -1)
+
+	if dist == 0 {
+		return result, hitPoint, hitNormal, false, 0, false
+	}
+
+	hitPoint = add3(rayStart, scale3(rayDir, dist))
+
+	// The colors and normals are computed in the object space.
+	localPoint := hitPoint
+	transform, transformed := getThingTransform(things, closestIdx)
+	if transformed {
+		localPoint = transformPoint(transform, hitPoint)
+	}
+
+	if t := getThingType(closestThing); t == SphereType {
+		result = diffuseSphere(closestThing, localPoint, materials)
+		center, radius, _ := getSphere(closestThing)
+		hitNormal = scale3(sub3(localPoint, center), 1/radius)
+	} else if t == PlaneType {
+		result = diffusePlane(closestThing, localPoint, materials)
+		hitNormal = normalPlane(closestThing, localPoint)
+	} else if t == ConeType {
+		result = diffuseCone(closestThing, localPoint, materials)
+		hitNormal = normalCone(closestThing, localPoint)
+	} else if t == CylinderType {
+		result = diffuseCylinder(closestThing, localPoint, materials)
+		hitNormal = normalCylinder(closestThing, localPoint)
+	} else if t == TriangleType {
+		result = diffuseTriangle(closestThing, localPoint, materials)
+		// The vertex normals, if any, are in the next thing.
+		normals := closestThing
+		if closestIdx+1 < getThingCount(things) {
+			normals = getThing(things, closestIdx+1)
+		}
+		hitNormal = normalTriangle(closestThing, normals, localPoint)
+	} else if t == TorusType {
+		result = diffuseTorus(closestThing, localPoint, materials)
+		hitNormal = normalTorus(closestThing, localPoint)
+	} else if t == BoxType {
+		result = diffuseBox(closestThing, localPoint, materials)
+		hitNormal = normalBox(closestThing, localPoint)
+	} else if t == QuadType {
+		result = diffuseQuad(closestThing, localPoint, materials)
+		hitNormal = normalQuad(closestThing, localPoint)
+	} else if t == DiscType {
+		result = diffuseDisc(closestThing, localPoint, materials)
+		hitNormal = normalDisc(closestThing, localPoint)
+	} else {
+		return newVec4(1, 1, 0, 1), hitPoint, hitNormal, false, 0, false // Error color.
+	}
+	matIdx = getThingMaterialIdx(closestThing)
+	// The pattern alternates with its second material, in the UV coordinates of the thing.
+	patternType, patternSize, patternMaterialIdx := getMaterialPattern(materials, matIdx)
+	if patternType != 0 {
+		uv := newVec2(hitPoint.x, hitPoint.z)
+		if patternType != WorldCheckerPattern {
+			uv = getThingUV(closestThing, localPoint)
+		}
+		if patternAlternate(patternType, patternSize, uv) {
+			matIdx = patternMaterialIdx
+			result = getMaterialColor(materials, matIdx)
+		}
+	}
+	// The texture is applied in the object space too, so it follows the transform, unless it is a world space one.
+	if textureIdx := getMaterialTexture(materials, matIdx); textureIdx >= 0 {
+		result = mul4(result, textureColor(textures, textureIdx, closestThing, hitPoint, localPoint))
+	}
+	surfaceColor := result
+
+	// The normal map or the bump tilts the normal used for the lighting and the bounces,
+	// in the object space unless the bump is in the world space.
+	shadingNormal := hitNormal
+	normalIdx := getMaterialNormalTexture(materials, matIdx)
+	if normalIdx >= 0 && !textureInWorld(textures, normalIdx) {
+		shadingNormal = perturbNormal(textures, normalIdx, closestThing, localPoint, hitNormal)
+	}
+	if transformed {
+		hitNormal = transformNormal(transform, hitNormal)
+		shadingNormal = transformNormal(transform, shadingNormal)
+	}
+	if normalIdx >= 0 && textureInWorld(textures, normalIdx) {
+		shadingNormal = perturbNormal(textures, normalIdx, closestThing, hitPoint, hitNormal)
+	}
+	if isInvertedThing(closestThing) {
+		hitNormal = scale3(hitNormal, -1)
+		shadingNormal = scale3(shadingNormal, -1)
+	}
+
+	// The lighting uses the outward shading normal, so the back sides stay dark, except for the two-sided triangles.
+	lightNormal := shadingNormal
+	// Face the returned normal toward the ray for the refraction, hitting the back side means the ray is inside the thing.
+	// The side is given by the geometric normal, the tilted one can face away at grazing angles.
+	if dot3(hitNormal, rayDir) > 0 {
+		shadingNormal = scale3(shadingNormal, -1)
+		inside = true
+		if getThingType(closestThing) == TriangleType {
+			lightNormal = shadingNormal
+		}
+	}
+	hitNormal = shadingNormal
+
+	_, matAmbient, matDiffuse, matSpecular, matSpecularPower, _ := getMaterial(materials, matIdx)
+	pbr, metallic, roughness := getMaterialPBR(materials, matIdx)
+	// The transparent part of the surface shows what is behind it instead of its own color.
+	transparency, _ := getMaterialTransparency(materials, matIdx)
+	matAmbient *= 1 - transparency
+	matDiffuse *= 1 - transparency
+
+	// Initialize the result with the ambient light.
+	_, ambientLightColor, ambientLightIntensity := getLight(ambientLight)
+	result = mul4(scale4(result, matAmbient), scale4(ambientLightColor, ambientLightIntensity))
+
+	for i := 0; i < len(lights); i++ {
+		light := lights[i]
+		if getLightType(light) == 0 { // Padding, end of the list.
+			break
+		}
+
+		// Get the light fields from the object.
+		_, lightColor, lightIntensity := getLight(light)
+
+		// The area lights are sampled with several shadow rays, giving soft shadows.
+		samples := getLightSamples(light)
+		lightSeed := hashSeed(seed, i)
+		for j := 0; j < maxLightSamples; j++ {
+			if j >= samples {
+				break
+			}
+
+			// Calculate the light direction, distance and attenuation.
+			lightDir, lightDistance, attenuation := getLightIncidence(light, hitPoint, j, samples, lightSeed)
+			if attenuation == 0 { // Not lit, e.g. outside of the spot light cone.
+				continue
+			}
+
+			// Re-cast from the hit point to the light source.
+			filter := shadowFilter(hitPoint, lightDir, lightDistance, things, bvh, materials)
+			if maxComponent4(filter) == 0 { // If we hit something opaque, we don't see the light, so move forward.
+				continue
+			}
+
+			// Otherwise, we have the light source in sight, possibly through transparent things.
+
+			viewDir := normalize3(scale3(rayDir, -1))
+			var combined vec4
+			if pbr {
+				combined = cookTorrance(surfaceColor, lightNormal, viewDir, lightDir, metallic, roughness, 1-transparency)
+			} else {
+				// Diffuse lighting.
+				diffFactor := max(0, dot3(lightNormal, lightDir))
+				diffuse := scale4(surfaceColor, matDiffuse*diffFactor)
+
+				// Specular lighting.
+				reflectDir := reflect3(scale3(lightDir, -1), lightNormal)
+				specFactor := pow(max(0, dot3(viewDir, reflectDir)), matSpecularPower)
+				specular := scale4(lightColor, matSpecular*specFactor)
+
+				// Combine diffuse and specular components.
+				combined = add4(diffuse, specular)
+			}
+
+			// Apply the light color and intensity, filtered by the transparent things in the way.
+			combined = scale4(mul4(mul4(combined, lightColor), filter), lightIntensity)
+
+			// Apply the attenuation (distance, spot light cone), averaged over the samples.
+			combined = scale4(combined, attenuation/float(samples))
+
+			result = add4(result, combined)
+		}
+	}
+
+	return result, hitPoint, hitNormal, inside, matIdx, true
+}
@@ -0,0 +1,59 @@
+package main
+
+// The transformed things are followed by their transform, the inverse of the object to world matrix,
+// used to bring the rays to the object space. The triangles have the transform applied to their vertices instead.
+
+// t[0].x = type - transform
+// t[1].xyzw = first row of the inverse matrix, the w column being the translation
+// t[2].xyzw = second row
+// t[3].xyzw = third row
+func newTransform(row0, row1, row2 vec4) mat4 {
+	return newMat4(
+		newVec4(TransformType, 0, 0, 0),
+		row0,
+		row1,
+		row2,
+	)
+}
+
+// getThingTransform returns the transform of the thing at the given index, ok is false if it has none.
+func getThingTransform(things ThingsT, idx int) (transform mat4, ok bool) {
+	if idx+1 < getThingCount(things) && getThingType(getThing(things, idx+1)) == TransformType {
+		return getThing(things, idx+1), true
+	}
+	return transform, false
+}
+
+// transformPoint brings the world point to the object space.
+func transformPoint(transform mat4, p vec3) vec3 {
+	return newVec3(
+		dot3(transform[1].xyz, p)+transform[1].w,
+		dot3(transform[2].xyz, p)+transform[2].w,
+		dot3(transform[3].xyz, p)+transform[3].w,
+	)
+}
+
+// transformDir brings the world direction to the object space. The result is not normalized,
+// so the distances along the ray stay the same in both spaces.
+func transformDir(transform mat4, d vec3) vec3 {
+	return newVec3(dot3(transform[1].xyz, d), dot3(transform[2].xyz, d), dot3(transform[3].xyz, d))
+}
+
+// transformNormal brings the object space normal back to the world space,
+// with the transpose of the inverse matrix.
+func transformNormal(transform mat4, n vec3) vec3 {
+	return normalize3(add3(add3(scale3(transform[1].xyz, n.x), scale3(transform[2].xyz, n.y)), scale3(transform[3].xyz, n.z)))
+}
+
+// intersectThing returns the distance to the thing at the given index, in the object space if it is transformed.
+// hitIdx is the index of the thing hit, a child for the CSG.
+func intersectThing(rayStart, rayDir vec3, things ThingsT, idx int, minDist, maxDist float) (dist float, hitIdx int) {
+	thing := getThing(things, idx)
+	if getThingType(thing) == CSGType {
+		return hitCSG(rayStart, rayDir, things, idx, minDist, maxDist)
+	}
+	if transform, ok := getThingTransform(things, idx); ok {
+		return intersect(transformPoint(transform, rayStart), transformDir(transform, rayDir), thing, minDist, maxDist), idx
+	}
+	return intersect(rayStart, rayDir, thing, minDist, maxDist), idx
+}
@@ -16,6 +16,15 @@ func (v vec2) uniform() []float32 {
 	return []float32{float32(v.x), float32(v.y)}
 }
 
+func (v *vec2) UnmarshalJSON(data []byte) error {
+	var arr []float
+	if err := json.Unmarshal(data, &arr); err != nil || len(arr) != 2 {
+		return fmt.Errorf("expected an array of 2 numbers, got %s", data)
+	}
+	*v = newVec2(arr[0], arr[1])
+	return nil
+}
+
 type vec3 struct {
 	vec2
 	xy vec2
@@ -23,18 +32,14 @@ type vec3 struct {
 }
 
 func (v *vec3) UnmarshalJSON(data []byte) error {
-	var arr [3]float
-	if err := json.Unmarshal(data, &arr); err != nil {
-		return err
+	var arr []float
+	if err := json.Unmarshal(data, &arr); err != nil || len(arr) != 3 {
+		return fmt.Errorf("expected an array of 3 numbers, got %s", data)
 	}
 	*v = newVec3(arr[0], arr[1], arr[2])
 	return nil
 }
 
-func (v vec3) marshalConstructor() string {
-	return fmt.Sprintf("newVec3(%f, %f, %f)", v.x, v.y, v.z)
-}
-
 func (v vec3) String() string {
 	return fmt.Sprintf("{%.2g,%.2g,%.2g}", v.x, v.y, v.z)
 }
@@ -54,17 +59,16 @@ func (v vec4) String() string {
 	return fmt.Sprintf("{%.2g,%.2g,%.2g,%.2g}", v.x, v.y, v.z, v.w)
 }
 
+// UnmarshalJSON accepts 3 or 4 components, w defaults to 0.
 func (v *vec4) UnmarshalJSON(data []byte) error {
-	var arr [4]float
-	if err := json.Unmarshal(data, &arr); err != nil {
-		return err
+	var arr []float
+	if err := json.Unmarshal(data, &arr); err != nil || len(arr) < 3 || len(arr) > 4 {
+		return fmt.Errorf("expected an array of 3 or 4 numbers, got %s", data)
 	}
+	arr = append(arr, 0)
 	*v = newVec4(arr[0], arr[1], arr[2], arr[3])
 	return nil
 }
-func (v vec4) marshalConstructor() string {
-	return fmt.Sprintf("newVec4(%f, %f, %f, %f)", v.x, v.y, v.z, v.w)
-}
 
 func (v vec4) uniform() []float32 {
 	xyz := v.vec3.uniform()
@@ -75,6 +79,14 @@ type float = float64
 
 type mat4 [4]vec4
 
+func (m mat4) uniform() []float32 {
+	out := make([]float32, 0, 16)
+	for _, elem := range m {
+		out = append(out, elem.uniform()...)
+	}
+	return out
+}
+
 func length3(v vec3) float {
 	return sqrt(dot3(v, v))
 }
@@ -100,6 +112,16 @@ func reflect3(v, normal vec3) vec3 {
 	return sub3(v, scale3(normal, 2*dot3(v, normal)))
 }
 
+// refract3 mirrors GLSL's refract, it returns a zero vector on total internal reflection.
+func refract3(v, normal vec3, eta float) vec3 {
+	d := dot3(normal, v)
+	k := 1 - eta*eta*(1-d*d)
+	if k < 0 {
+		return newVec3(0, 0, 0)
+	}
+	return sub3(scale3(v, eta), scale3(normal, eta*d+math.Sqrt(k)))
+}
+
 func cos(in float) float     { return math.Cos(in) }
 func sin(in float) float     { return math.Sin(in) }
 func tan(in float) float     { return math.Tan(in) }
@@ -110,7 +132,9 @@ func atan2(y, x float) float { return math.Atan2(y, x) }
 func sqrt(in float) float    { return math.Sqrt(in) }
 func pow(in, n float) float  { return math.Pow(in, n) }
 func floor(in float) float   { return math.Floor(in) }
+func ceil(in float) float    { return math.Ceil(in) }
 func abs(in float) float     { return math.Abs(in) }
+func exp2(in float) float    { return math.Exp2(in) }
 
 const pi = math.Pi
 
@@ -125,9 +149,15 @@ var (
 	_ = sqrt(0)
 	_ = pow(0, 0)
 	_ = floor(0)
+	_ = ceil(0)
 	_ = abs(0)
+	_ = exp2(0)
 )
 
+func newVec2(x, y float) vec2 {
+	return vec2{x, y}
+}
+
 func newVec3(x, y, z float) vec3 {
 	var v vec3
 	v.xy.x = x
@@ -3,214 +3,599 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"image"
 )
 
 // This file is a wrapper for Kage. It mirror the shaderlib_rtv1.kage file to allow
 // for the shader to be compile in Go.
 // In this part, we have the RTv1 specific functions and types.
 
+// ThingsT holds the things of the scene.
+// In the shader, it is only the descriptor of the things in the source image, see k_rtv1_data.go.
 type ThingsT []mat4
 
 type LightsT []mat4
 
 type MaterialsT []mat4
 
-// Globals used to pass the scene to the Fragment function.
-// In shader mode, it the constructors get injected.
-// Not using Uniform as it doesn't support complex types nor arrays.
-var (
-	sceneObjects   ThingsT
-	sceneLights    LightsT
-	sceneMaterials MaterialsT
-	ambientLight   mat4
-)
+// BVHT holds the header and the nodes of the BVH, stored in the source image like the things.
+type BVHT []mat4
 
-type sphere struct {
-	Center   vec3   `json:"center"`
-	Radius   float  `json:"radius"`
-	Material string `json:"material"`
+func getThing(things ThingsT, idx int) mat4 {
+	return things[idx]
 }
 
-func (s *sphere) UnmarshalJSON(data []byte) error {
-	type alias sphere
-	if err := json.Unmarshal(data, (*alias)(s)); err != nil {
-		return err
-	}
-	if s.Radius <= 0 {
-		return fmt.Errorf("radius must be greater than 0")
+func getThingCount(things ThingsT) int {
+	return len(things)
+}
+
+func getBVHEntry(bvh BVHT, idx int) mat4 {
+	return bvh[idx]
+}
+
+func getBVHEntryCount(bvh BVHT) int {
+	return len(bvh)
+}
+
+// TexturesT holds the textures, see k_rtv1_texture.go.
+// In the shader, it is only the array of the descriptors, the atlas being at the top of the source image.
+type TexturesT struct {
+	items []mat4
+	atlas *image.RGBA
+}
+
+func getTexture(textures TexturesT, idx int) mat4 {
+	return textures.items[idx]
+}
+
+// getTextureTexel returns the texel of the atlas at the given pixel coordinates.
+func getTextureTexel(textures TexturesT, x, y int) vec4 {
+	c := textures.atlas.RGBAAt(x, y)
+	return newVec4(float(c.R)/255, float(c.G)/255, float(c.B)/255, float(c.A)/255)
+}
+
+// sceneData holds the scene encoded for the shader code.
+// The CPU renderer passes it to renderPixel, in shader mode, it is passed as uniforms and source image.
+type sceneData struct {
+	things       ThingsT
+	bvh          BVHT
+	thingsBlock  vec4        // Where the things are in the source image, see getDataMat4 in k_shaderlib_rtv1.kage.
+	bvhBlock     vec4        // Where the BVH is in the source image.
+	source       *image.RGBA // Source image of the shader, see packSceneData.
+	lights       LightsT
+	materials    MaterialsT
+	textures     TexturesT
+	ambientLight mat4
+}
+
+// uniforms flattens the scene data to be passed to the shader.
+// The arrays are padded to the shader capacity, the shader stops at the first empty light.
+// The things and the BVH are in the source image, only their descriptors are uniforms.
+func (d sceneData) uniforms(c shaderCapacity) map[string]any {
+	flatten := func(in []mat4, size int) []float32 {
+		out := make([]float32, 0, size*16)
+		for _, elem := range in {
+			out = append(out, elem.uniform()...)
+		}
+		return append(out, make([]float32, (size-len(in))*16)...)
 	}
-	if s.Center == (vec3{}) {
-		return fmt.Errorf("missing 'center'")
+	return map[string]any{
+		"UniThings":       d.thingsBlock.uniform(),
+		"UniBVH":          d.bvhBlock.uniform(),
+		"UniLights":       flatten(d.lights, c.lights),
+		"UniMaterials":    flatten(d.materials, c.materials),
+		"UniTextures":     flatten(d.textures.items, c.textures),
+		"UniAmbientLight": d.ambientLight.uniform(),
 	}
-	return nil
 }
 
-func (s sphere) mat4() mat4 { return newSphere(s.Center, s.Radius, materialTypeIndex[s.Material]) }
+type sphere struct {
+	Center   vec3   `json:"center"`
+	Radius   float  `json:"radius"`
+	Material string `json:"material"`
+
+	materialIdx int
+}
 
-func (s sphere) marshalConstructor() string {
-	return fmt.Sprintf("newSphere(%s, %f, %d)", s.Center.marshalConstructor(), s.Radius, materialTypeIndex[s.Material])
+func (s *sphere) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "radius", "material")
+	v.positive(path, "radius", s.Radius)
+	s.materialIdx = v.material(path, s.Material)
 }
 
+func (s sphere) things() ThingsT { return ThingsT{newSphere(s.Center, s.Radius, s.materialIdx)} }
+
 type plane struct {
 	Center         vec3   `json:"center"`
 	Normal         vec3   `json:"normal"`
 	IsCheckerboard bool   `json:"is_checkerboard"`
 	CheckerSize    float  `json:"checker_size"`
 	Material       string `json:"material"`
+
+	materialIdx int
 }
 
-func (p *plane) UnmarshalJSON(data []byte) error {
-	type alias plane
-	if err := json.Unmarshal(data, (*alias)(p)); err != nil {
-		return err
+func (p *plane) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "normal", "material")
+	if v.ok(joinPath(path, "normal")) && length3(p.Normal) == 0 {
+		v.errorf(joinPath(path, "normal"), "must not be a zero vector")
 	}
-	if p.IsCheckerboard && p.CheckerSize <= 0 {
-		return fmt.Errorf("checker size must be greater than 0")
+	if p.IsCheckerboard {
+		v.require(path, "checker_size")
+		v.positive(path, "checker_size", p.CheckerSize)
 	}
-	if p.Center == (vec3{}) {
-		return fmt.Errorf("missing 'center'")
+	p.materialIdx = v.material(path, p.Material)
+	if p.IsCheckerboard && p.materialIdx != -1 && p.CheckerSize > 0 {
+		p.materialIdx = checkerboardMaterial(v, p.materialIdx, p.CheckerSize)
 	}
-	if p.Normal == (vec3{}) {
-		return fmt.Errorf("missing 'normal'")
-	}
-	return nil
 }
 
-func (p plane) mat4() mat4 {
-	return newPlane(p.Center, p.Normal, p.IsCheckerboard, p.CheckerSize, materialTypeIndex[p.Material])
+// checkerboardMaterial returns the index of a copy of the given material with a checker pattern,
+// alternating with a dark version of it, for the is_checkerboard planes.
+// The checker is in the world X/Z coordinates, like before the patterns, so the old scenes render unchanged.
+// The copies are shared by the planes with the same material and size.
+func checkerboardMaterial(v *sceneValidator, idx int, size float) int {
+	m := v.materialAt(idx)
+	name := fmt.Sprintf("%s (checkerboard %g)", m.Type, size)
+	if checkerIdx, ok := v.addedMaterial(name); ok {
+		return checkerIdx
+	}
+	dark := m
+	dark.Type = name + " dark"
+	dark.Color = newVec4(0.1, 0.1, 0.1, 1)
+	dark.pattern = nil
+	m.Type = name
+	m.pattern = &pattern{Type: "checker", Size: size, materialIdx: v.addMaterial(dark.Type, dark), world: true}
+	return v.addMaterial(name, m)
 }
 
-func (p plane) marshalConstructor() string {
-	return fmt.Sprintf("newPlane(%s, %s, %t, %f, %d)",
-		p.Center.marshalConstructor(),
-		p.Normal.marshalConstructor(),
-		p.IsCheckerboard,
-		p.CheckerSize,
-		materialTypeIndex[p.Material],
-	)
+func (p plane) things() ThingsT {
+	return ThingsT{newPlane(p.Center, p.Normal, p.materialIdx)}
 }
 
 type cylinder struct {
 	Center1  vec3   `json:"center1"`
 	Center2  vec3   `json:"center2"`
 	Radius   float  `json:"radius"`
+	Caps     bool   `json:"caps"`
+	Infinite bool   `json:"infinite"` // Defined by center and axis instead of center1 and center2.
+	Center   vec3   `json:"center"`
+	Axis     vec3   `json:"axis"`
 	Material string `json:"material"`
-}
 
-func (c *cylinder) UnmarshalJSON(data []byte) error {
-	type alias cylinder
-	if err := json.Unmarshal(data, (*alias)(c)); err != nil {
-		return err
-	}
-	if c.Radius <= 0 {
-		return fmt.Errorf("radius must be greater than 0")
-	}
-	if c.Center1 == (vec3{}) || c.Center2 == (vec3{}) {
-		return fmt.Errorf("missing 'center1' or 'center2'")
-	}
-	return nil
+	materialIdx int
 }
 
-func (c cylinder) mat4() mat4 {
-	return newCylinder(c.Center1, c.Center2, c.Radius, materialTypeIndex[c.Material])
+func (c *cylinder) validate(v *sceneValidator, path string) {
+	v.require(path, "radius", "material")
+	v.positive(path, "radius", c.Radius)
+	if c.Infinite {
+		v.require(path, "center", "axis")
+		if v.ok(joinPath(path, "axis")) && length3(c.Axis) == 0 {
+			v.errorf(joinPath(path, "axis"), "must not be a zero vector")
+		}
+		if c.Caps {
+			v.errorf(joinPath(path, "caps"), "an infinite cylinder can't have caps")
+		}
+		c.Center1, c.Center2 = c.Center, add3(c.Center, c.Axis)
+	} else {
+		v.require(path, "center1", "center2")
+		if c.Center1 == c.Center2 && v.ok(joinPath(path, "center1")) && v.ok(joinPath(path, "center2")) {
+			v.errorf(path, "center1 and center2 must be different")
+		}
+	}
+	c.materialIdx = v.material(path, c.Material)
 }
 
-func (c cylinder) marshalConstructor() string {
-	return fmt.Sprintf("newCylinder(%s, %s, %f, %d)",
-		c.Center1.marshalConstructor(),
-		c.Center2.marshalConstructor(),
-		c.Radius,
-		materialTypeIndex[c.Material],
-	)
+func (c cylinder) things() ThingsT {
+	return ThingsT{newCylinder(c.Center1, c.Center2, c.Radius, c.Caps, c.Infinite, c.materialIdx)}
 }
 
 type cone struct {
 	Apex     vec3   `json:"apex"`
 	Base     vec3   `json:"base"`
 	Radius   float  `json:"radius"`
+	Caps     bool   `json:"caps"`
+	Infinite bool   `json:"infinite"` // Defined by apex, axis and angle instead of base and radius.
+	Axis     vec3   `json:"axis"`
+	Angle    float  `json:"angle"` // Half angle at the apex, in degrees.
 	Material string `json:"material"`
+
+	materialIdx int
 }
 
-func (c *cone) UnmarshalJSON(data []byte) error {
-	type alias cone
-	if err := json.Unmarshal(data, (*alias)(c)); err != nil {
-		return err
+func (c *cone) validate(v *sceneValidator, path string) {
+	v.require(path, "apex", "material")
+	if c.Infinite {
+		v.require(path, "axis", "angle")
+		if v.ok(joinPath(path, "axis")) && length3(c.Axis) == 0 {
+			v.errorf(joinPath(path, "axis"), "must not be a zero vector")
+		}
+		if v.ok(joinPath(path, "angle")) && (c.Angle <= 0 || c.Angle >= 90) {
+			v.errorf(joinPath(path, "angle"), "must be between 0 and 90, got %g", c.Angle)
+		}
+		if c.Caps {
+			v.errorf(joinPath(path, "caps"), "an infinite cone can't have caps")
+		}
+		// The radius at a distance of 1 from the apex.
+		if length3(c.Axis) != 0 {
+			c.Base = add3(c.Apex, normalize3(c.Axis))
+		}
+		c.Radius = tan(c.Angle * pi / 180)
+	} else {
+		v.require(path, "base", "radius")
+		v.positive(path, "radius", c.Radius)
+		if c.Apex == c.Base && v.ok(joinPath(path, "apex")) && v.ok(joinPath(path, "base")) {
+			v.errorf(path, "apex and base must be different")
+		}
 	}
-	if c.Radius <= 0 {
-		return fmt.Errorf("radius must be greater than 0")
+	c.materialIdx = v.material(path, c.Material)
+}
+
+func (c cone) things() ThingsT {
+	return ThingsT{newCone(c.Base, c.Apex, c.Radius, c.Caps, c.Infinite, c.materialIdx)}
+}
+
+type torus struct {
+	Center      vec3   `json:"center"`
+	Axis        vec3   `json:"axis"`
+	MajorRadius float  `json:"major_radius"` // From the center to the middle of the tube.
+	MinorRadius float  `json:"minor_radius"` // Of the tube.
+	Material    string `json:"material"`
+
+	materialIdx int
+}
+
+func (t *torus) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "axis", "major_radius", "minor_radius", "material")
+	v.positive(path, "major_radius", t.MajorRadius)
+	v.positive(path, "minor_radius", t.MinorRadius)
+	if v.ok(joinPath(path, "axis")) && length3(t.Axis) == 0 {
+		v.errorf(joinPath(path, "axis"), "must not be a zero vector")
 	}
-	if c.Apex == (vec3{}) || c.Base == (vec3{}) {
-		return fmt.Errorf("missing 'apex' or 'base'")
+	t.materialIdx = v.material(path, t.Material)
+}
+
+func (t torus) things() ThingsT {
+	axis := t.Axis
+	if length3(axis) != 0 {
+		axis = normalize3(axis)
 	}
-	return nil
+	return ThingsT{newTorus(t.Center, axis, t.MajorRadius, t.MinorRadius, t.materialIdx)}
+}
+
+type box struct {
+	Center   vec3   `json:"center"`
+	Size     vec3   `json:"size"`   // Full size along each axis.
+	Rotate   vec3   `json:"rotate"` // Euler angles in degrees, as in the transforms, axis aligned by default.
+	Material string `json:"material"`
+
+	materialIdx int
 }
 
-func (c cone) mat4() mat4 {
-	return newCone(c.Base, c.Apex, c.Radius, materialTypeIndex[c.Material])
+func (b *box) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "size", "material")
+	if v.ok(joinPath(path, "size")) && (b.Size.x <= 0 || b.Size.y <= 0 || b.Size.z <= 0) {
+		v.errorf(joinPath(path, "size"), "must have positive components, got %s", b.Size)
+	}
+	b.materialIdx = v.material(path, b.Material)
 }
 
-func (c cone) marshalConstructor() string {
-	return fmt.Sprintf("newCone(%s, %s, %f, %d)",
-		c.Base.marshalConstructor(),
-		c.Apex.marshalConstructor(),
-		c.Radius,
-		materialTypeIndex[c.Material],
-	)
+func (b box) things() ThingsT {
+	rotation := eulerAffine(b.Rotate)
+	axisX := newVec3(rotation[0][0], rotation[1][0], rotation[2][0])
+	axisY := newVec3(rotation[0][1], rotation[1][1], rotation[2][1])
+	return ThingsT{newBox(b.Center, axisX, axisY, scale3(b.Size, 0.5), b.materialIdx)}
 }
 
+type quad struct {
+	Center   vec3   `json:"center"`
+	Edge1    vec3   `json:"edge1"` // Sides, centered on the center.
+	Edge2    vec3   `json:"edge2"`
+	Material string `json:"material"`
+
+	materialIdx int
+}
+
+func (q *quad) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "edge1", "edge2", "material")
+	if v.ok(joinPath(path, "edge1")) && v.ok(joinPath(path, "edge2")) && length3(cross3(q.Edge1, q.Edge2)) == 0 {
+		v.errorf(joinPath(path, "edge2"), "must not be a zero vector nor parallel to edge1")
+	}
+	q.materialIdx = v.material(path, q.Material)
+}
+
+func (q quad) things() ThingsT {
+	return ThingsT{newQuad(q.Center, scale3(q.Edge1, 0.5), scale3(q.Edge2, 0.5), q.materialIdx)}
+}
+
+type disc struct {
+	Center   vec3   `json:"center"`
+	Normal   vec3   `json:"normal"`
+	Radius   float  `json:"radius"`
+	Material string `json:"material"`
+
+	materialIdx int
+}
+
+func (d *disc) validate(v *sceneValidator, path string) {
+	v.require(path, "center", "normal", "radius", "material")
+	v.positive(path, "radius", d.Radius)
+	if v.ok(joinPath(path, "normal")) && length3(d.Normal) == 0 {
+		v.errorf(joinPath(path, "normal"), "must not be a zero vector")
+	}
+	d.materialIdx = v.material(path, d.Material)
+}
+
+func (d disc) things() ThingsT {
+	normal := d.Normal
+	if length3(normal) != 0 {
+		normal = normalize3(normal)
+	}
+	return ThingsT{newDisc(d.Center, normal, d.Radius, d.materialIdx)}
+}
+
+type triangle struct {
+	V0       vec3   `json:"v0"`
+	V1       vec3   `json:"v1"`
+	V2       vec3   `json:"v2"`
+	N0       vec3   `json:"n0"`
+	N1       vec3   `json:"n1"`
+	N2       vec3   `json:"n2"`
+	Material string `json:"material"`
+
+	materialIdx int
+	hasNormals  bool
+}
+
+func (t *triangle) validate(v *sceneValidator, path string) {
+	v.require(path, "v0", "v1", "v2", "material")
+	if v.ok(joinPath(path, "v0")) && v.ok(joinPath(path, "v1")) && v.ok(joinPath(path, "v2")) &&
+		length3(cross3(sub3(t.V1, t.V0), sub3(t.V2, t.V0))) == 0 {
+		v.errorf(path, "v0, v1 and v2 must not be aligned")
+	}
+	// The vertex normals are optional, but all or nothing.
+	if v.has(joinPath(path, "n0")) || v.has(joinPath(path, "n1")) || v.has(joinPath(path, "n2")) {
+		v.require(path, "n0", "n1", "n2")
+		for _, elem := range []struct {
+			field  string
+			normal vec3
+		}{{"n0", t.N0}, {"n1", t.N1}, {"n2", t.N2}} {
+			if v.ok(joinPath(path, elem.field)) && length3(elem.normal) == 0 {
+				v.errorf(joinPath(path, elem.field), "must not be a zero vector")
+			}
+		}
+		t.hasNormals = true
+	}
+	t.materialIdx = v.material(path, t.Material)
+}
+
+func (t triangle) things() ThingsT {
+	if !t.hasNormals {
+		return ThingsT{newTriangle(t.V0, t.V1, t.V2, false, t.materialIdx)}
+	}
+	return ThingsT{
+		newTriangle(t.V0, t.V1, t.V2, true, t.materialIdx),
+		newTriangleNormals(normalize3(t.N0), normalize3(t.N1), normalize3(t.N2)),
+	}
+}
+
+// defaultLightSamples is the number of shadow rays of the area lights when not set.
+const defaultLightSamples = 16
+
 type light struct {
-	Origin    vec3  `json:"origin"`
-	Color     vec4  `json:"color"`
-	Intensity float `json:"intensity"`
+	Type       string `json:"type"` // point (default), directional, spot, rect, disc or sphere.
+	Origin     vec3   `json:"origin"`
+	Direction  vec3   `json:"direction"` // Normal of the disc lights.
+	Color      vec4   `json:"color"`
+	Intensity  float  `json:"intensity"`
+	InnerAngle float  `json:"inner_angle"` // Spot light full intensity cone, in degrees.
+	OuterAngle float  `json:"outer_angle"` // Spot light cone, in degrees.
+	Edge1      vec3   `json:"edge1"`       // Rect light sides, centered on the origin.
+	Edge2      vec3   `json:"edge2"`
+	Radius     float  `json:"radius"`  // Disc and sphere lights.
+	Samples    int    `json:"samples"` // Shadow rays of the area lights.
+
+	lightType int
 }
 
-func (l light) mat4() mat4 { return newLight(l.Origin, l.Color, l.Intensity) }
+func (l *light) validate(v *sceneValidator, path string) {
+	v.require(path, "color")
+	if l.Intensity < 0 {
+		v.errorf(joinPath(path, "intensity"), "must not be negative, got %g", l.Intensity)
+	}
+
+	switch l.Type {
+	case "", "point":
+		l.lightType = PointLightType
+		v.require(path, "origin")
+	case "directional":
+		l.lightType = DirectionalLightType
+		v.require(path, "direction")
+	case "spot":
+		l.lightType = SpotLightType
+		v.require(path, "origin", "direction", "inner_angle", "outer_angle")
+		if v.ok(joinPath(path, "outer_angle")) && (l.OuterAngle <= 0 || l.OuterAngle > 90) {
+			v.errorf(joinPath(path, "outer_angle"), "must be between 0 and 90, got %g", l.OuterAngle)
+		}
+		if v.ok(joinPath(path, "inner_angle")) && (l.InnerAngle < 0 || l.InnerAngle > l.OuterAngle) {
+			v.errorf(joinPath(path, "inner_angle"), "must be between 0 and outer_angle, got %g", l.InnerAngle)
+		}
+	case "rect":
+		l.lightType = RectLightType
+		v.require(path, "origin", "edge1", "edge2")
+		if v.ok(joinPath(path, "edge1")) && v.ok(joinPath(path, "edge2")) && length3(cross3(l.Edge1, l.Edge2)) == 0 {
+			v.errorf(joinPath(path, "edge2"), "must not be a zero vector nor parallel to edge1")
+		}
+	case "disc":
+		l.lightType = DiscLightType
+		v.require(path, "origin", "direction", "radius")
+		v.positive(path, "radius", l.Radius)
+	case "sphere":
+		l.lightType = SphereLightType
+		v.require(path, "origin", "radius")
+		v.positive(path, "radius", l.Radius)
+	default:
+		v.errorf(joinPath(path, "type"), "unknown light type %q", l.Type)
+	}
+	if v.ok(joinPath(path, "direction")) && length3(l.Direction) == 0 {
+		v.errorf(joinPath(path, "direction"), "must not be a zero vector")
+	}
+
+	if !v.has(joinPath(path, "samples")) {
+		l.Samples = defaultLightSamples
+	} else if v.ok(joinPath(path, "samples")) && (l.Samples < 1 || l.Samples > maxLightSamples) {
+		v.errorf(joinPath(path, "samples"), "must be between 1 and %d, got %d", maxLightSamples, l.Samples)
+	}
+}
 
-func (l light) marshalConstructor() string {
-	return fmt.Sprintf("newLight(%s, %s, %f)", l.Origin.marshalConstructor(), l.Color.marshalConstructor(), l.Intensity)
+func (l light) mat4() mat4 {
+	direction := l.Direction
+	if length3(direction) != 0 {
+		direction = normalize3(direction)
+	}
+	if l.lightType == RectLightType || l.lightType == DiscLightType || l.lightType == SphereLightType {
+		axis := direction
+		if l.lightType == RectLightType {
+			axis = scale3(l.Edge1, 0.5)
+		}
+		return newAreaLight(l.lightType, l.Origin, axis, scale3(l.Edge2, 0.5), l.Radius, l.Color, l.Intensity, l.Samples)
+	}
+	return newLight(l.lightType, l.Origin, direction, l.Color, l.Intensity,
+		cos(l.InnerAngle*pi/180), cos(l.OuterAngle*pi/180))
 }
 
-// NOTE: Only populated/accessed at the start during the scene loading phase.
-//
-//	No concurrent access.
-var materialTypeIndex = map[string]int{}
+// defaultRoughness is the roughness of the pbr materials when not set.
+const defaultRoughness = 0.5
 
 type material struct {
-	Type            string `json:"type"`
-	Color           vec4   `json:"color"`
-	Ambient         float  `json:"ambient"`
-	Diffuse         float  `json:"diffuse"`
-	Specular        float  `json:"specular"`
-	SpecularPower   float  `json:"specular_power"`
-	ReflectiveIndex float  `json:"reflective_index"`
+	Type            string          `json:"type"`
+	Model           string          `json:"model"` // phong (default) or pbr.
+	Color           vec4            `json:"color"` // Base color of the pbr materials.
+	Ambient         float           `json:"ambient"`
+	Diffuse         float           `json:"diffuse"`
+	Specular        float           `json:"specular"`
+	SpecularPower   float           `json:"specular_power"`
+	ReflectiveIndex float           `json:"reflective_index"`
+	Transparency    float           `json:"transparency"`
+	RefractiveIndex float           `json:"refractive_index"`
+	Metallic        float           `json:"metallic"`  // pbr only.
+	Roughness       float           `json:"roughness"` // pbr only.
+	Texture         json.RawMessage `json:"texture"`
+	Pattern         json.RawMessage `json:"pattern"`
+	NormalMap       json.RawMessage `json:"normal_map"`
+	Bump            json.RawMessage `json:"bump"`
+
+	index            int
+	texture          *texture
+	textureIdx       int // In the scene textures.
+	pattern          *pattern
+	normalTexture    *texture // Normal map or bump.
+	normalTextureIdx int      // In the scene textures.
 }
 
-func (m *material) UnmarshalJSON(data []byte) error {
-	type alias material
-	if err := json.Unmarshal(data, (*alias)(m)); err != nil {
-		return err
+func (m *material) validate(v *sceneValidator, path string) {
+	v.require(path, "type", "color")
+	if v.ok(joinPath(path, "transparency")) && (m.Transparency < 0 || m.Transparency > 1) {
+		v.errorf(joinPath(path, "transparency"), "must be between 0 and 1, got %g", m.Transparency)
 	}
-	if _, ok := materialTypeIndex[m.Type]; ok {
-		return fmt.Errorf("duplicate material type: %q", m.Type)
+	if !v.has(joinPath(path, "refractive_index")) {
+		m.RefractiveIndex = 1 // Vacuum, the light goes through without bending.
+	}
+	v.positive(path, "refractive_index", m.RefractiveIndex)
+	m.validateModel(v, path)
+	if m.Texture != nil {
+		m.texture, m.textureIdx = decodeTexture(v, joinPath(path, "texture"), m.Texture, colorTexture)
+	}
+	if m.NormalMap != nil && m.Bump != nil {
+		v.errorf(joinPath(path, "bump"), "must not be set with normal_map")
+	} else if m.NormalMap != nil {
+		m.normalTexture, m.normalTextureIdx = decodeTexture(v, joinPath(path, "normal_map"), m.NormalMap, normalMapTexture)
+	} else if m.Bump != nil {
+		m.normalTexture, m.normalTextureIdx = decodeTexture(v, joinPath(path, "bump"), m.Bump, bumpTexture)
+	}
+	if m.Pattern != nil {
+		var p pattern
+		if patternPath := joinPath(path, "pattern"); v.decodeFields(patternPath, m.Pattern, &p) {
+			p.validate(v, patternPath)
+			m.pattern = &p // Its material is resolved once all the materials are known.
+		}
+	}
+	if !v.has(joinPath(path, "type")) {
+		return
+	}
+	if _, ok := v.materials[m.Type]; ok {
+		v.errorf(joinPath(path, "type"), "duplicate material %q", m.Type)
+		return
+	}
+	m.index = len(v.materials)
+	v.materials[m.Type] = m.index
+}
+
+// validateModel checks the fields of the shading model, the Phong and pbr ones being exclusive.
+func (m *material) validateModel(v *sceneValidator, path string) {
+	if !v.has(joinPath(path, "model")) {
+		m.Model = "phong"
+	}
+	switch m.Model {
+	case "phong":
+		for _, field := range []string{"metallic", "roughness"} {
+			if v.has(joinPath(path, field)) {
+				v.errorf(joinPath(path, field), "only used by the pbr model")
+			}
+		}
+		// The negative specular powers are reserved to flag the pbr materials in the shader, see PBRModel.
+		if v.ok(joinPath(path, "specular_power")) && m.SpecularPower < 0 {
+			v.errorf(joinPath(path, "specular_power"), "must not be negative, got %g", m.SpecularPower)
+		}
+	case "pbr":
+		for _, field := range []string{"diffuse", "specular", "specular_power", "reflective_index"} {
+			if v.has(joinPath(path, field)) {
+				v.errorf(joinPath(path, field), "not used by the pbr model")
+			}
+		}
+		if !v.has(joinPath(path, "roughness")) {
+			m.Roughness = defaultRoughness
+		}
+		for _, elem := range []struct {
+			field string
+			value float
+		}{{"metallic", m.Metallic}, {"roughness", m.Roughness}} {
+			if v.ok(joinPath(path, elem.field)) && (elem.value < 0 || elem.value > 1) {
+				v.errorf(joinPath(path, elem.field), "must be between 0 and 1, got %g", elem.value)
+			}
+		}
+	default:
+		if v.ok(joinPath(path, "model")) {
+			v.errorf(joinPath(path, "model"), "unknown model %q, expected phong or pbr", m.Model)
+		}
 	}
-	materialTypeIndex[m.Type] = len(materialTypeIndex)
-	return nil
 }
 
 func (m material) mat4() mat4 {
-	return newMaterial(materialTypeIndex[m.Type], m.Color, m.Ambient, m.Diffuse, m.Specular, m.SpecularPower, m.ReflectiveIndex)
-}
-
-func (m material) marshalConstructor() string {
-	return fmt.Sprintf("newMaterial(%d, %s, %f, %f, %f, %f, %f)",
-		materialTypeIndex[m.Type],
-		m.Color.marshalConstructor(),
-		m.Ambient,
-		m.Diffuse,
-		m.Specular,
-		m.SpecularPower,
-		m.ReflectiveIndex,
-	)
+	textureIdx := -1
+	if m.texture != nil {
+		textureIdx = m.textureIdx
+	}
+	var patternType, patternMaterialIdx int
+	var patternSize float
+	if m.pattern != nil {
+		patternType, patternSize, patternMaterialIdx = patternTypes[m.pattern.Type], m.pattern.Size, m.pattern.materialIdx
+		if m.pattern.world {
+			patternType = WorldCheckerPattern
+		}
+	}
+	normalTextureIdx := -1
+	if m.normalTexture != nil {
+		normalTextureIdx = m.normalTextureIdx
+	}
+	// The pbr materials have their metallic and roughness in place of the Phong coefficients.
+	diffuse, specular, specularPower := m.Diffuse, m.Specular, m.SpecularPower
+	if m.Model == "pbr" {
+		diffuse, specular, specularPower = m.Metallic, m.Roughness, PBRModel
+	}
+	return newMaterial(m.Color, m.Ambient, diffuse, specular, specularPower, m.ReflectiveIndex, m.Transparency, m.RefractiveIndex,
+		textureIdx, patternType, patternSize, patternMaterialIdx, normalTextureIdx)
 }
 
 type camera struct {
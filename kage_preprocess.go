@@ -3,11 +3,61 @@ package main
 import (
 	_ "embed"
 	"fmt"
-	"strconv"
 	"strings"
 )
 
-func preprocess(s scene, files ...[]byte) string {
+// preprocessVersion is part of the shader cache key, bump it when preprocess changes its output for the same files.
+const preprocessVersion = 2
+
+// maxUniformVectors is the max number of vec4 uniforms of the shader, the limit of most desktop GPUs.
+const maxUniformVectors = 1024
+
+// reservedUniformVectors is the number of vec4 uniforms kept for the camera, the other scalars and Ebiten's own.
+const reservedUniformVectors = 32
+
+// shaderCapacity is the number of scene elements a compiled shader can hold.
+// The lights, materials and textures are passed as fixed size uniform arrays, so the capacity is set at compile time.
+// The things and the BVH are stored in the source image, their number is not limited by the shader.
+type shaderCapacity struct {
+	lights, materials, textures int
+}
+
+// fits returns true if the given scene data fits in the capacity.
+func (c shaderCapacity) fits(d sceneData) bool {
+	return len(d.lights) <= c.lights && len(d.materials) <= c.materials && len(d.textures.items) <= c.textures
+}
+
+// grow returns the capacity grown to hold the given scene data.
+// If it would go over the uniforms limit, the capacity of the scene alone is used instead.
+func (c shaderCapacity) grow(d sceneData) shaderCapacity {
+	grown := shaderCapacity{
+		lights:    max(c.lights, len(d.lights)),
+		materials: max(c.materials, len(d.materials)),
+		textures:  max(c.textures, len(d.textures.items)),
+	}
+	if grown.validate() != nil {
+		return shaderCapacity{lights: len(d.lights), materials: len(d.materials), textures: len(d.textures.items)}
+	}
+	return grown
+}
+
+// uniformVectors returns the number of vec4 uniforms of the shader.
+func (c shaderCapacity) uniformVectors() int {
+	// The ambient light and the things and BVH descriptors.
+	const sceneVectors = 4 + 1 + 1
+	return reservedUniformVectors + sceneVectors + 4*(c.lights+c.materials+c.textures)
+}
+
+// validate returns an error if the shader would have more uniforms than the GPUs support.
+func (c shaderCapacity) validate() error {
+	if n := c.uniformVectors(); n > maxUniformVectors {
+		return fmt.Errorf("scene too large for the shader: %d lights, %d materials and %d textures need %d uniform vectors, up to %d are supported",
+			c.lights, c.materials, c.textures, n, maxUniformVectors)
+	}
+	return nil
+}
+
+func preprocess(c shaderCapacity, files ...[]byte) string {
 	// Remove the "package main" line from the secondary files.
 	str := string(files[0])
 	for _, elem := range files[1:] {
@@ -15,135 +65,23 @@ func preprocess(s scene, files ...[]byte) string {
 		str += stripped
 	}
 
-	// Inject the scene objects.
-	for _, elem := range []struct {
-		k string
-		f func() string
-	}{
-		{"things", s.marshalInjectThings},
-		{"lights", s.marshalInjectLights},
-		{"materials", s.marshalInjectMaterials},
-		{"ambientLight", func() string { return `ambientLight := ` + s.AmbientLight.marshalConstructor() }},
-	} {
-		str = strings.ReplaceAll(str, "//scene:"+elem.k, elem.f())
-	}
+	// The things and the BVH are descriptors of the data stored in the source image.
+	str = strings.ReplaceAll(str, "ThingsT", "vec4")
+	str = strings.ReplaceAll(str, "BVHT", "vec4")
 
-	// Replace the custom types with their underlying equivalents.
+	// Replace the custom types with their underlying fixed size equivalents.
 	for _, elem := range []struct {
 		CustomType string
 		ItemType   string
 		ArraySize  int
 	}{
-		{"ThingsT", "mat4", len(s.Objects)},
-		{"LightsT", "mat4", len(s.Lights)},
-		{"MaterialsT", "mat4", len(s.Materials)},
+		{"LightsT", "mat4", c.lights},
+		{"MaterialsT", "mat4", c.materials},
+		{"TexturesT", "mat4", c.textures},
 	} {
 		underlying := fmt.Sprintf("[%d]"+elem.ItemType, elem.ArraySize)
 		str = strings.ReplaceAll(str, elem.CustomType, underlying)
 	}
 
-	// Current recursive function block.
-	curFct := ""
-	fcts := map[string]string{}
-
-	// Current recursive stop condition block.
-	curIf := ""
-	ifs := map[string]string{}
-
-	// Regular lines, outside any directive blocks.
-	regular := ""
-
-	// "Lexer" part. Populate buffers based on tokens.
-	lines := strings.Split(str, "\n")
-	for i := 0; i+1 < len(lines); i++ {
-		line := lines[i]
-		if strings.Contains(line, "//rec:") {
-			directiveParts := strings.Split(line, "//rec:")
-			if len(directiveParts) != 2 {
-				panic(fmt.Errorf("invalid '//rec:' declaration: %q", line))
-			}
-			directive := directiveParts[1]
-			parts := strings.Split(directive, ":")
-			if len(parts) != 2 {
-				panic(fmt.Errorf("invalid '//rec:' directive: %q", parts))
-			}
-			switch parts[0] {
-			case "func":
-				curFct = parts[1]
-				if !strings.Contains(lines[i+1], fmt.Sprintf("func %s(", parts[1])) {
-					panic(fmt.Errorf("invalid '//rec:func', no matching %q func on next line", parts[1]))
-				}
-				lines[i+1] = strings.ReplaceAll(
-					lines[i+1],
-					fmt.Sprintf("func %s(", parts[1]),
-					fmt.Sprintf("func %s(", parts[1]+"__REC__"),
-				)
-			case "endfunc":
-				if parts[1] != curFct {
-					panic(fmt.Errorf("invalid '//rec:endfunc', expected %q, got %q", curFct, parts[1]))
-				}
-				if curIf != "" {
-					panic(fmt.Errorf("invalid '//rec:endfunc' inside '//rec:if'"))
-				}
-				curFct = ""
-
-			case "if":
-				if curFct == "" {
-					panic(fmt.Errorf("invalid '//rec:if' outside '//rec:func'"))
-				}
-				curIf = parts[1]
-			case "endif":
-				if curFct == "" {
-					panic(fmt.Errorf("invalid '//rec:endif' outside '//rec:func'"))
-				}
-				if parts[1] != curIf {
-					panic(fmt.Errorf("invalid '//rec:endif', expected %q, got %q", curIf, parts[1]))
-				}
-				fcts[curFct] += "//rec:if:" + curFct + "-" + curIf + "\n"
-				curIf = ""
-
-				// Forward call. Each depth level will have the depth number as suffix. Expect the first one which is the original name.
-			case "call":
-				lines[i+1] = strings.ReplaceAll(lines[i+1], parts[1], parts[1]+"__REC__")
-				// Actuall recursive call. Each depth level will have the next depth number as suffix.
-			case "rec-call":
-				lines[i+1] = strings.ReplaceAll(lines[i+1], parts[1], parts[1]+"__REC+1__")
-			default:
-				panic(fmt.Errorf("unknown '//rec:' directive: %q", parts[0]))
-			}
-			continue
-		}
-
-		if curFct != "" {
-			if curIf != "" {
-				ifs[curFct+"-"+curIf] += line + "\n"
-			} else {
-				fcts[curFct] += line + "\n"
-			}
-		} else {
-			regular += line + "\n"
-		}
-	}
-
-	// Generate the recursive code.
-
-	out := regular
-	for i := range maxDepth {
-		for _, content := range fcts {
-			if i < maxDepth-1 {
-				for ifName, ifContent := range ifs {
-					content = strings.ReplaceAll(content, "//rec:if:"+ifName, ifContent)
-				}
-			}
-			n := ""
-			if i > 0 {
-				n = strconv.Itoa(i)
-			}
-			content = strings.ReplaceAll(content, "__REC__", n)
-			content = strings.ReplaceAll(content, "__REC+1__", strconv.Itoa(i+1))
-			out += content + "\n"
-		}
-	}
-
-	return out
+	return str
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestSpotFalloff checks the spot light is full inside the inner cone, dark outside the outer one
+// and fades smoothly in between.
+func TestSpotFalloff(t *testing.T) {
+	t.Parallel()
+
+	spot := light{Type: "spot", Origin: newVec3(0, 0, 0), Direction: newVec3(0, -2, 0), Intensity: 1, InnerAngle: 20, OuterAngle: 30, lightType: SpotLightType}
+	in := spot.mat4()
+
+	// Points at distance 1 from the light, so the inverse square law doesn't attenuate.
+	at := func(degrees float) float {
+		a := degrees * math.Pi / 180
+		_, dist, attenuation := getLightIncidence(in, newVec3(math.Sin(a), -math.Cos(a), 0), 0, 1, 0)
+		if math.Abs(dist-1) > 1e-9 {
+			t.Fatalf("Unexpected distance %g at %g°, expected 1.", dist, degrees)
+		}
+		return attenuation
+	}
+
+	for _, degrees := range []float{0, 10, 19.9} {
+		if got := at(degrees); math.Abs(got-1) > 1e-9 {
+			t.Errorf("Unexpected attenuation %g at %g°, expected full light in the inner cone.", got, degrees)
+		}
+	}
+	for _, degrees := range []float{30.1, 45, 90, 180} {
+		if got := at(degrees); got != 0 {
+			t.Errorf("Unexpected attenuation %g at %g°, expected no light outside the outer cone.", got, degrees)
+		}
+	}
+	prev := 1.0
+	for degrees := 20.5; degrees < 30; degrees += 0.5 {
+		got := at(degrees)
+		if got <= 0 || got >= prev {
+			t.Fatalf("Unexpected attenuation %g at %g°, expected strictly between 0 and %g.", got, degrees, prev)
+		}
+		prev = got
+	}
+}
+
+// TestDirectionalLight checks the directional light comes from the same direction everywhere, without attenuation.
+func TestDirectionalLight(t *testing.T) {
+	t.Parallel()
+
+	sun := light{Type: "directional", Direction: newVec3(0, -3, 0), Intensity: 1, lightType: DirectionalLightType}
+	in := sun.mat4()
+	for _, pos := range []vec3{newVec3(0, 0, 0), newVec3(100, -50, 3)} {
+		lightDir, dist, attenuation := getLightIncidence(in, pos, 0, 1, 0)
+		if lightDir != newVec3(0, 1, 0) || dist != -1 || attenuation != 1 {
+			t.Errorf("Unexpected incidence at %v: direction %v, distance %g, attenuation %g.", pos, lightDir, dist, attenuation)
+		}
+	}
+}
+
+// TestAreaLightSamples checks each sample of a rect light lands in its own cell of the light,
+// so the shadow rays cover the whole light.
+func TestAreaLightSamples(t *testing.T) {
+	t.Parallel()
+
+	const samples = 16 // 4x4 cells.
+	rect := light{Type: "rect", Origin: newVec3(0, 5, 0), Edge1: newVec3(4, 0, 0), Edge2: newVec3(0, 0, 4), Intensity: 1, Samples: samples, lightType: RectLightType}
+	in := rect.mat4()
+	pos := newVec3(0, 0, 0)
+
+	seen := map[[2]int]bool{}
+	for j := range samples {
+		lightDir, dist, attenuation := getLightIncidence(in, pos, j, samples, 42)
+		p := add3(pos, scale3(lightDir, dist))
+		if math.Abs(p.y-5) > 1e-9 || math.Abs(p.x) > 2 || math.Abs(p.z) > 2 {
+			t.Fatalf("Unexpected sample %d at %v, out of the light.", j, p)
+		}
+		if attenuation <= 0 {
+			t.Errorf("Unexpected attenuation %g for sample %d.", attenuation, j)
+		}
+		cell := [2]int{int(p.x + 2), int(p.z + 2)}
+		if cell != [2]int{j % 4, j / 4} {
+			t.Errorf("Unexpected sample %d in cell %v.", j, cell)
+		}
+		seen[cell] = true
+	}
+	if len(seen) != samples {
+		t.Errorf("Unexpected %d cells sampled, expected %d.", len(seen), samples)
+	}
+}
@@ -2,8 +2,8 @@
 package main
 
 import (
+	"fmt"
 	_ "image/png"
-	"log"
 	"os"
 )
 
@@ -24,29 +24,14 @@ const (
 )
 
 func main() {
-	s, err := loadScene("")
-	if err != nil {
-		log.Fatalf("Failed to load scene scene.json: %s.", err)
+	// Headless mode, render a single frame to a PNG file without opening a window.
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering: %s.\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	g := &Game{
-		scene:    s,
-		sceneIdx: 0,
-
-		renderMode: RenderModeGPU,
-	}
-
-	// TODO: Document the fake shader aspect.
-	// WHen the fake shader mode is enabled, set the render to be CPU.
-	if os.Getenv("FAKE_SHADER") == "1" {
-		g.renderMode = RenderModeCPU
-	}
-
-	// If we are in GPU render mode, compile the shader in the background.
-	// Wait a little for the window to be created.
-	if g.renderMode == RenderModeGPU {
-		g.shader = compileShader(g.scene)
-	}
-
-	g.run()
+	runGame()
 }
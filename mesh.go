@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// This file holds the mesh object, loaded from Wavefront OBJ files.
+// Only the geometry (v, vn, f) and the materials (mtllib, usemtl) are supported, the rest is ignored.
+//
+// Example:
+//
+//	{"type": "mesh", "file": "bunny.obj", "center": [0, -0.5, 0], "scale": 2}
+
+// defaultMeshMaterial is the material of the faces without usemtl when the mesh doesn't set one.
+// The scene can define it to override the built-in one.
+const defaultMeshMaterial = "default"
+
+type mesh struct {
+	File     string `json:"file"`
+	Material string `json:"material"` // Material of the faces without usemtl.
+	Center   vec3   `json:"center"`   // Offset applied to the vertices.
+	Scale    float  `json:"scale"`
+
+	triangles []triangle
+}
+
+func (m *mesh) validate(v *sceneValidator, path string) {
+	v.require(path, "file")
+	if !v.has(joinPath(path, "scale")) {
+		m.Scale = 1
+	}
+	v.positive(path, "scale", m.Scale)
+	defaultMaterialIdx := -1
+	if v.has(joinPath(path, "material")) {
+		defaultMaterialIdx = v.material(path, m.Material)
+	}
+	if !v.ok(joinPath(path, "file")) {
+		return
+	}
+
+	obj, err := loadOBJ(v.fsys, m.File)
+	if err != nil {
+		v.errorf(joinPath(path, "file"), "%s", err)
+		return
+	}
+
+	// The usemtl names are looked up in the scene materials first, then in the MTL files.
+	// The MTL materials are added once per file, so the meshes sharing a file share its materials
+	// while the same name in another file is another material.
+	materials := map[mtlKey]int{}
+	resolve := func(f objFace) int {
+		if idx, ok := materials[f.material]; ok {
+			return idx
+		}
+		key := f.material.lib + ":" + f.material.name
+		idx, ok := v.materials[f.material.name]
+		if !ok {
+			idx, ok = v.addedMaterial(key)
+		}
+		if !ok {
+			if mtl, found := obj.materials[f.material]; found {
+				idx = v.addMaterial(key, mtl)
+			} else {
+				v.errorf(joinPath(path, "file"), "%s:%d: unknown material %q, not in the scene nor in the mtllib files", m.File, f.materialLine, f.material.name)
+				idx = -1
+			}
+		}
+		materials[f.material] = idx
+		return idx
+	}
+
+	m.triangles = make([]triangle, 0, len(obj.faces))
+	for _, f := range obj.faces {
+		materialIdx := defaultMaterialIdx
+		if f.material.name != "" {
+			materialIdx = resolve(f)
+		} else if !v.has(joinPath(path, "material")) {
+			materialIdx = defaultMeshMaterialIdx(v)
+		}
+		t := triangle{
+			V0:          add3(scale3(f.v[0], m.Scale), m.Center),
+			V1:          add3(scale3(f.v[1], m.Scale), m.Center),
+			V2:          add3(scale3(f.v[2], m.Scale), m.Center),
+			N0:          f.n[0],
+			N1:          f.n[1],
+			N2:          f.n[2],
+			materialIdx: materialIdx,
+			hasNormals:  f.hasNormals,
+		}
+		m.triangles = append(m.triangles, t)
+	}
+}
+
+// defaultMeshMaterialIdx returns the index of the material of the faces without usemtl,
+// when neither the mesh nor the scene set one.
+func defaultMeshMaterialIdx(v *sceneValidator) int {
+	if idx, ok := v.materials[defaultMeshMaterial]; ok {
+		return idx
+	}
+	if idx, ok := v.addedMaterial(defaultMeshMaterial); ok {
+		return idx
+	}
+	return v.addMaterial(defaultMeshMaterial, material{Type: defaultMeshMaterial, Color: newVec4(0.8, 0.8, 0.8, 1), Ambient: 0.1, Diffuse: 0.8, Specular: 0.2, SpecularPower: 32, RefractiveIndex: 1})
+}
+
+func (m mesh) things() ThingsT {
+	out := make(ThingsT, 0, len(m.triangles)*2)
+	for _, elem := range m.triangles {
+		out = append(out, elem.things()...)
+	}
+	return out
+}
+
+// mtlKey identifies a material of an MTL file, the same name can be defined by several files.
+// The lib is empty for the usemtl names not defined by any of the mtllib files.
+type mtlKey struct {
+	lib, name string
+}
+
+// objFace is a triangle of an OBJ file.
+type objFace struct {
+	v            [3]vec3
+	n            [3]vec3
+	hasNormals   bool
+	material     mtlKey
+	materialLine int // Of the usemtl statement.
+}
+
+// objFile is the content of an OBJ file.
+type objFile struct {
+	faces     []objFace
+	materials map[mtlKey]material // From the mtllib files.
+}
+
+// loadOBJ reads the given OBJ file and the MTL files it references.
+// Polygons are split in triangles, degenerated ones are skipped.
+func loadOBJ(fsys fs.FS, name string) (objFile, error) {
+	buf, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return objFile{}, fmt.Errorf("failed to read mesh: %w", err)
+	}
+
+	obj := objFile{materials: map[mtlKey]material{}}
+	var (
+		vertices    []vec3
+		normals     []vec3
+		libs        []string // Loaded MTL files, in order.
+		current     mtlKey   // Current material.
+		currentLine int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		fail := func(format string, args ...any) (objFile, error) {
+			return objFile{}, fmt.Errorf("%s:%d: %s", name, lineNo, fmt.Sprintf(format, args...))
+		}
+
+		switch fields[0] {
+		case "v":
+			arr, err := parseFloats(fields[1:], 3)
+			if err != nil {
+				return fail("invalid vertex: %s", err)
+			}
+			vertices = append(vertices, newVec3(arr[0], arr[1], arr[2]))
+		case "vn":
+			arr, err := parseFloats(fields[1:], 3)
+			if err != nil {
+				return fail("invalid normal: %s", err)
+			}
+			normals = append(normals, newVec3(arr[0], arr[1], arr[2]))
+		case "f":
+			if len(fields) < 4 {
+				return fail("a face needs at least 3 vertices")
+			}
+			var (
+				faceVertices []vec3
+				faceNormals  []vec3
+			)
+			for _, elem := range fields[1:] {
+				// Formats: v, v/vt, v//vn, v/vt/vn.
+				parts := strings.Split(elem, "/")
+				vIdx, err := parseOBJIndex(parts[0], len(vertices))
+				if err != nil {
+					return fail("invalid face vertex %q: %s", elem, err)
+				}
+				faceVertices = append(faceVertices, vertices[vIdx])
+				if len(parts) == 3 && parts[2] != "" {
+					nIdx, err := parseOBJIndex(parts[2], len(normals))
+					if err != nil {
+						return fail("invalid face normal %q: %s", elem, err)
+					}
+					faceNormals = append(faceNormals, normals[nIdx])
+				}
+			}
+			// The vertex normals are used only if all the vertices have a usable one.
+			hasNormals := len(faceNormals) == len(faceVertices)
+			for _, elem := range faceNormals {
+				hasNormals = hasNormals && length3(elem) != 0
+			}
+			// Fan triangulation.
+			for i := 1; i+1 < len(faceVertices); i++ {
+				f := objFace{
+					v:            [3]vec3{faceVertices[0], faceVertices[i], faceVertices[i+1]},
+					material:     current,
+					materialLine: currentLine,
+				}
+				if length3(cross3(sub3(f.v[1], f.v[0]), sub3(f.v[2], f.v[0]))) == 0 {
+					continue
+				}
+				if hasNormals {
+					f.n = [3]vec3{faceNormals[0], faceNormals[i], faceNormals[i+1]}
+					f.hasNormals = true
+				}
+				obj.faces = append(obj.faces, f)
+			}
+		case "usemtl":
+			if len(fields) != 2 {
+				return fail("usemtl expects a single name")
+			}
+			// The last loaded file defining the name wins.
+			current, currentLine = mtlKey{name: fields[1]}, lineNo
+			for _, lib := range libs {
+				if _, ok := obj.materials[mtlKey{lib, fields[1]}]; ok {
+					current.lib = lib
+				}
+			}
+		case "mtllib":
+			for _, elem := range fields[1:] {
+				lib := path.Join(path.Dir(name), elem)
+				materials, err := loadMTL(fsys, lib)
+				if err != nil {
+					return fail("%s", err)
+				}
+				for mtlName, mtl := range materials {
+					obj.materials[mtlKey{lib, mtlName}] = mtl
+				}
+				libs = append(libs, lib)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return objFile{}, fmt.Errorf("failed to read mesh: %w", err)
+	}
+	if len(obj.faces) == 0 {
+		return objFile{}, fmt.Errorf("%s: no faces", name)
+	}
+
+	return obj, nil
+}
+
+// loadMTL reads the materials of the given MTL file, by name.
+// Kd is the color, Ka and Ks the ambient and specular factors, Ns the specular power,
+// d (or Tr = 1-d) the opacity and Ni the refractive index.
+func loadMTL(fsys fs.FS, name string) (map[string]material, error) {
+	buf, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read material library: %w", err)
+	}
+
+	materials := map[string]material{}
+	var current *material
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "newmtl" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: newmtl expects a single name", name, lineNo)
+			}
+			m := material{Type: fields[1], Color: newVec4(0.8, 0.8, 0.8, 1), Ambient: 0.1, Diffuse: 1, SpecularPower: 32, RefractiveIndex: 1}
+			materials[m.Type] = m
+			current = &m
+			continue
+		}
+		if current == nil {
+			continue // Statements before the first newmtl are ignored.
+		}
+
+		var n int
+		switch fields[0] {
+		case "Kd", "Ka", "Ks":
+			n = 3
+		case "Ns", "d", "Tr", "Ni":
+			n = 1
+		default:
+			continue
+		}
+		arr, err := parseFloats(fields[1:], n)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid %s: %w", name, lineNo, fields[0], err)
+		}
+		switch fields[0] {
+		case "Kd":
+			current.Color = newVec4(arr[0], arr[1], arr[2], current.Color.w)
+		case "Ka":
+			current.Ambient = (arr[0] + arr[1] + arr[2]) / 3
+		case "Ks":
+			current.Specular = (arr[0] + arr[1] + arr[2]) / 3
+		case "Ns":
+			current.SpecularPower = max(1, arr[0])
+		case "d":
+			current.Transparency = min(1, max(0, 1-arr[0]))
+		case "Tr":
+			current.Transparency = min(1, max(0, arr[0]))
+		case "Ni":
+			current.RefractiveIndex = max(1, arr[0])
+		}
+		materials[current.Type] = *current
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read material library: %w", err)
+	}
+	return materials, nil
+}
+
+// parseFloats parses the first n fields as numbers, the extra ones are ignored (e.g. the optional w of the vertices).
+func parseFloats(fields []string, n int) ([]float, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected %d numbers, got %d", n, len(fields))
+	}
+	out := make([]float, n)
+	for i := range out {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", fields[i])
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// parseOBJIndex converts a 1-based OBJ index to a 0-based one. Negative indices are relative to the end.
+func parseOBJIndex(s string, count int) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", s)
+	}
+	if idx < 0 {
+		idx += count
+	} else {
+		idx--
+	}
+	if idx < 0 || idx >= count {
+		return 0, fmt.Errorf("index %s out of range", s)
+	}
+	return idx, nil
+}
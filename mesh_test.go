@@ -0,0 +1,268 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadOBJ(t *testing.T) {
+	t.Parallel()
+
+	const vertices = "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n"
+	const normals = "vn 0 0 1\nvn 0 0 -1\n"
+
+	for _, tc := range []struct {
+		name       string
+		obj        string
+		faces      [][3]vec3 // Vertices of the expected faces.
+		hasNormals bool
+		normal     vec3 // Of the first vertex of the first face, when hasNormals.
+	}{
+		{
+			name:  "positive indices",
+			obj:   vertices + "f 1 2 3\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:  "negative indices",
+			obj:   vertices + "f -4 -3 -2\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:  "negative indices are relative to the vertices so far",
+			obj:   "v 0 0 0\nv 1 0 0\nv 1 1 0\nf -3 -2 -1\nv 5 5 5\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:  "quad split in two",
+			obj:   vertices + "f 1 2 3 4\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}, {newVec3(0, 0, 0), newVec3(1, 1, 0), newVec3(0, 1, 0)}},
+		},
+		{
+			name:  "texture coordinates ignored",
+			obj:   vertices + "vt 0 0\nf 1/1 2/1 3/1\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:       "vertex normals",
+			obj:        vertices + normals + "f 1//2 2//2 3//2\n",
+			faces:      [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+			hasNormals: true,
+			normal:     newVec3(0, 0, -1),
+		},
+		{
+			name:       "negative vertex normals",
+			obj:        vertices + normals + "f -4//-2 -3//-2 -2//-2\n",
+			faces:      [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+			hasNormals: true,
+			normal:     newVec3(0, 0, 1),
+		},
+		{
+			name:       "texture coordinates and normals",
+			obj:        vertices + normals + "vt 0 0\nf 1/1/1 2/1/1 3/1/1\n",
+			faces:      [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+			hasNormals: true,
+			normal:     newVec3(0, 0, 1),
+		},
+		{
+			name:  "normals on some vertices only",
+			obj:   vertices + normals + "f 1//1 2 3//1\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:  "degenerated face skipped",
+			obj:   vertices + "f 1 2 2\nf 1 2 3\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+		{
+			name:  "comments and unknown statements",
+			obj:   "# A triangle.\no tri\n" + vertices + "s off\nf 1 2 3\n",
+			faces: [][3]vec3{{newVec3(0, 0, 0), newVec3(1, 0, 0), newVec3(1, 1, 0)}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			obj, err := loadOBJ(fstest.MapFS{"m.obj": {Data: []byte(tc.obj)}}, "m.obj")
+			if err != nil {
+				t.Fatalf("Unexpected error: %s.", err)
+			}
+			if len(obj.faces) != len(tc.faces) {
+				t.Fatalf("Unexpected number of faces: %d, expected %d.", len(obj.faces), len(tc.faces))
+			}
+			for i, elem := range obj.faces {
+				if elem.v != tc.faces[i] {
+					t.Errorf("Unexpected vertices of face %d: %v, expected %v.", i, elem.v, tc.faces[i])
+				}
+				if elem.hasNormals != tc.hasNormals {
+					t.Errorf("Unexpected hasNormals of face %d: %t, expected %t.", i, elem.hasNormals, tc.hasNormals)
+				}
+			}
+			if tc.hasNormals && obj.faces[0].n[0] != tc.normal {
+				t.Errorf("Unexpected normal: %v, expected %v.", obj.faces[0].n[0], tc.normal)
+			}
+		})
+	}
+}
+
+func TestLoadOBJErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		obj  string
+		want string
+	}{
+		{"index out of range", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 4\n", `m.obj:4: invalid face vertex "4": index 4 out of range`},
+		{"negative index out of range", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf -1 -2 -4\n", `m.obj:4: invalid face vertex "-4": index -4 out of range`},
+		{"zero index", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf 0 1 2\n", `m.obj:4: invalid face vertex "0": index 0 out of range`},
+		{"vertex defined after the face", "v 0 0 0\nv 1 0 0\nf 1 2 3\nv 1 1 0\n", `m.obj:3: invalid face vertex "3": index 3 out of range`},
+		{"normal out of range", "v 0 0 0\nv 1 0 0\nv 1 1 0\nvn 0 0 1\nf 1//1 2//2 3//1\n", `m.obj:5: invalid face normal "2//2": index 2 out of range`},
+		{"invalid index", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf 1 2 x\n", `m.obj:4: invalid face vertex "x": invalid index "x"`},
+		{"two vertices", "v 0 0 0\nv 1 0 0\nf 1 2\n", "m.obj:3: a face needs at least 3 vertices"},
+		{"invalid vertex", "v 0 0\n", "m.obj:1: invalid vertex: expected 3 numbers, got 2"},
+		{"invalid normal", "vn 0 a 1\n", `m.obj:1: invalid normal: invalid number "a"`},
+		{"no faces", "v 0 0 0\n", "m.obj: no faces"},
+		{"missing mtllib", "mtllib missing.mtl\n", "m.obj:1: failed to read material library: open missing.mtl: file does not exist"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadOBJ(fstest.MapFS{"m.obj": {Data: []byte(tc.obj)}}, "m.obj")
+			if err == nil {
+				t.Fatalf("Expected an error, got none.")
+			}
+			if err.Error() != tc.want {
+				t.Fatalf("Unexpected error:\n%s\nexpected:\n%s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadMTL(t *testing.T) {
+	t.Parallel()
+
+	materials, err := loadMTL(fstest.MapFS{"m.mtl": {Data: []byte(`# Materials.
+Ka 1 1 1
+newmtl red
+Kd 1 0 0
+Ka 0.3 0.3 0.3
+Ks 0.5 0.5 0.5
+Ns 64
+
+newmtl glass
+Kd 0.9 0.9 1
+d 0.25
+Ni 1.5
+illum 4
+
+newmtl plain
+`)}}, "m.mtl")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+
+	for name, want := range map[string]material{
+		"red":   {Type: "red", Color: newVec4(1, 0, 0, 1), Ambient: 0.3, Diffuse: 1, Specular: 0.5, SpecularPower: 64, RefractiveIndex: 1},
+		"glass": {Type: "glass", Color: newVec4(0.9, 0.9, 1, 1), Ambient: 0.1, Diffuse: 1, SpecularPower: 32, Transparency: 0.75, RefractiveIndex: 1.5},
+		"plain": {Type: "plain", Color: newVec4(0.8, 0.8, 0.8, 1), Ambient: 0.1, Diffuse: 1, SpecularPower: 32, RefractiveIndex: 1},
+	} {
+		got, ok := materials[name]
+		if !ok {
+			t.Errorf("Missing material %q.", name)
+			continue
+		}
+		if got.Type != want.Type || got.Color != want.Color || got.Ambient != want.Ambient || got.Diffuse != want.Diffuse || got.Specular != want.Specular ||
+			got.SpecularPower != want.SpecularPower || got.Transparency != want.Transparency || got.RefractiveIndex != want.RefractiveIndex {
+			t.Errorf("Unexpected material %q:\n%+v\nexpected:\n%+v", name, got, want)
+		}
+	}
+	if len(materials) != 3 {
+		t.Errorf("Unexpected number of materials: %d, expected 3.", len(materials))
+	}
+}
+
+func TestLoadMTLErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		mtl  string
+		want string
+	}{
+		{"newmtl without name", "newmtl\n", "m.mtl:1: newmtl expects a single name"},
+		{"invalid color", "newmtl red\nKd 1 0\n", "m.mtl:2: invalid Kd: expected 3 numbers, got 2"},
+		{"invalid number", "newmtl red\nNs high\n", `m.mtl:2: invalid Ns: invalid number "high"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadMTL(fstest.MapFS{"m.mtl": {Data: []byte(tc.mtl)}}, "m.mtl")
+			if err == nil {
+				t.Fatalf("Expected an error, got none.")
+			}
+			if err.Error() != tc.want {
+				t.Fatalf("Unexpected error:\n%s\nexpected:\n%s", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestMeshMaterials checks the usemtl names resolve to the scene materials first, then to the MTL file
+// they come from, the same name in two files being two materials.
+func TestMeshMaterials(t *testing.T) {
+	t.Parallel()
+
+	const tri = "v 0 0 0\nv 1 0 0\nv 0 1 0\n"
+	fsys := fstest.MapFS{
+		"a.obj": {Data: []byte("mtllib a.mtl\n" + tri + "usemtl skin\nf 1 2 3\nusemtl red\nf 1 2 3\n")},
+		"b.obj": {Data: []byte("mtllib b.mtl\n" + tri + "usemtl skin\nf 1 2 3\n")},
+		"c.obj": {Data: []byte("mtllib a.mtl b.mtl\n" + tri + "usemtl skin\nf 1 2 3\nf 1 2 3\n")},
+		"d.obj": {Data: []byte(tri + "f 1 2 3\n")},
+		"a.mtl": {Data: []byte("newmtl skin\nKd 1 0.8 0.6\nnewmtl red\nKd 0 0 1\n")},
+		"b.mtl": {Data: []byte("newmtl skin\nKd 0.2 0.1 0\n")},
+	}
+	s, err := parseScene(fsys, []byte(`{
+  "camera": {"origin": [0, 0, 5], "lookAt": [0, 0, 0]},
+  "materials": [{"type": "red", "color": [1, 0, 0, 1]}],
+  "objects": [
+    {"type": "mesh", "file": "a.obj"},
+    {"type": "mesh", "file": "b.obj"},
+    {"type": "mesh", "file": "a.obj"},
+    {"type": "mesh", "file": "c.obj"},
+    {"type": "mesh", "file": "d.obj"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+
+	colorOf := func(obj, face int) vec4 {
+		m, ok := s.Objects[obj].(*mesh)
+		if !ok {
+			t.Fatalf("Unexpected object %d: %T, expected a mesh.", obj, s.Objects[obj])
+		}
+		return s.Materials[m.triangles[face].materialIdx].Color
+	}
+	for _, tc := range []struct {
+		name      string
+		obj, face int
+		want      vec4
+	}{
+		{"from a.mtl", 0, 0, newVec4(1, 0.8, 0.6, 1)},
+		{"scene material first", 0, 1, newVec4(1, 0, 0, 1)},
+		{"same name in b.mtl", 1, 0, newVec4(0.2, 0.1, 0, 1)},
+		{"a.mtl again", 2, 0, newVec4(1, 0.8, 0.6, 1)},
+		{"last mtllib wins", 3, 0, newVec4(0.2, 0.1, 0, 1)},
+		{"default material", 4, 0, newVec4(0.8, 0.8, 0.8, 1)},
+	} {
+		if got := colorOf(tc.obj, tc.face); got != tc.want {
+			t.Errorf("%s: unexpected color %v, expected %v.", tc.name, got, tc.want)
+		}
+	}
+
+	// The scene material, skin from both files and the default one, a.obj loaded twice sharing its materials.
+	if len(s.Materials) != 4 {
+		t.Errorf("Unexpected number of materials: %d, expected 4.", len(s.Materials))
+	}
+}
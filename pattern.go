@@ -0,0 +1,34 @@
+package main
+
+// This file holds the patterns of the materials. The alternation is in k_rtv1_pattern.go.
+//
+// Example:
+//
+//	{"type": "tiles", "color": [0.9, 0.9, 0.9, 1], "diffuse": 0.8, "pattern": {"type": "checker", "size": 0.5, "material": "black"}}
+
+var patternTypes = map[string]int{
+	"checker": CheckerPattern,
+	"stripes": StripesPattern,
+	"grid":    GridPattern,
+}
+
+type pattern struct {
+	Type string `json:"type"` // checker, stripes or grid.
+	// Of the cells, in the UV coordinates of the thing: scene units for the planes, a fraction of the surface for the others.
+	Size     float  `json:"size"`
+	Material string `json:"material"` // Of the odd cells, the odd stripes or the grid lines.
+
+	materialIdx int
+	world       bool // In the world X/Z coordinates, for the legacy is_checkerboard planes.
+}
+
+func (p *pattern) validate(v *sceneValidator, path string) {
+	v.require(path, "type", "material")
+	if _, ok := patternTypes[p.Type]; !ok && v.ok(joinPath(path, "type")) {
+		v.errorf(joinPath(path, "type"), "unknown pattern type %q, expected checker, stripes or grid", p.Type)
+	}
+	if !v.has(joinPath(path, "size")) {
+		p.Size = 1
+	}
+	v.positive(path, "size", p.Size)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// TestPatternAlternate checks the patterns keep alternating across the origin,
+// where an int modulo would give the wrong parity for the negative cells.
+func TestPatternAlternate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		pType int
+		uv    vec2
+		want  bool
+	}{
+		{"checker origin cell", CheckerPattern, newVec2(0.5, 0.5), false},
+		{"checker right", CheckerPattern, newVec2(2.5, 0.5), true},
+		{"checker left", CheckerPattern, newVec2(-0.5, 0.5), true},
+		{"checker down", CheckerPattern, newVec2(0.5, -0.5), true},
+		{"checker diagonal", CheckerPattern, newVec2(-0.5, -0.5), false},
+		{"checker far negative", CheckerPattern, newVec2(-4.5, -4.5), false},
+		{"checker far negative odd", CheckerPattern, newVec2(-6.5, -0.5), true},
+		{"stripes", StripesPattern, newVec2(0.5, 10), false},
+		{"stripes odd", StripesPattern, newVec2(2.5, -10), true},
+		{"stripes negative", StripesPattern, newVec2(-0.5, 0), true},
+		{"stripes negative even", StripesPattern, newVec2(-2.5, 0), false},
+		{"grid line", GridPattern, newVec2(2.1, 3), true},
+		{"grid negative line", GridPattern, newVec2(-1.9, 5), true},
+		{"grid cell", GridPattern, newVec2(-1, -1), false},
+		{"no pattern", 0, newVec2(-2, 0), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := patternAlternate(tc.pType, 2, tc.uv); got != tc.want {
+				t.Errorf("Unexpected alternation %t at %v, expected %t.", got, tc.uv, tc.want)
+			}
+		})
+	}
+}
+
+// TestLegacyCheckerboard checks the is_checkerboard cells stay aligned to the world X/Z axes,
+// whatever the plane center, as before the patterns.
+func TestLegacyCheckerboard(t *testing.T) {
+	t.Parallel()
+
+	s, err := parseScene(fstest.MapFS{}, []byte(`{
+  "camera": {"origin": [0, 5, 5], "lookAt": [0, 0, 0]},
+  "materials": [{"type": "white", "color": [1, 1, 1, 1], "diffuse": 1}],
+  "objects": [{"type": "plane", "center": [0.25, -1, 0.75], "normal": [0, 1, 0], "is_checkerboard": true, "checker_size": 1, "material": "white"}]
+}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+	data, err := newSceneData(s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+
+	for _, tc := range []struct {
+		x, z float
+		dark bool
+	}{
+		{0.5, 0.5, false},
+		{0.1, 0.9, false},
+		{1.5, 0.5, true},
+		{0.5, -0.5, true},
+		{-0.5, -0.5, false},
+		{-1.5, 2.5, false},
+		{-2.5, 2.5, true},
+	} {
+		_, _, _, _, matIdx, hit := shade(newVec3(tc.x, 1, tc.z), newVec3(0, -1, 0), data.lights, data.things, data.bvh, data.materials, data.textures, data.ambientLight, 0)
+		if !hit {
+			t.Fatalf("Unexpected miss at %g, %g.", tc.x, tc.z)
+		}
+		if dark := getMaterialColor(data.materials, matIdx) == newVec4(0.1, 0.1, 0.1, 1); dark != tc.dark {
+			t.Errorf("Unexpected cell at %g, %g: dark %t, expected %t.", tc.x, tc.z, dark, tc.dark)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCookTorranceEnergy checks the physically based materials don't reflect more light than they get,
+// for random directions around the normal, the pi of cookTorrance put aside.
+func TestCookTorranceEnergy(t *testing.T) {
+	t.Parallel()
+
+	normal := newVec3(0, 1, 0)
+	white := newVec4(1, 1, 1, 1)
+	// Uniform directions over the hemisphere: the cosine to the normal is uniform.
+	hemisphere := func(seed int) vec3 {
+		cosTheta, phi := random(hashSeed(seed, 0)), 2*math.Pi*random(hashSeed(seed, 1))
+		sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+		return newVec3(sinTheta*math.Cos(phi), cosTheta, sinTheta*math.Sin(phi))
+	}
+	for _, metallic := range []float{0, 0.5, 1} {
+		for _, roughness := range []float{0.3, 0.6, 1} {
+			// Average over the light directions for a fixed view: the reflected energy, cosine included.
+			const samples = 20000
+			for v := range 4 {
+				viewDir := hemisphere(v)
+				var sum float
+				for i := range samples {
+					lightDir := hemisphere(1000 + i)
+					c := cookTorrance(white, normal, viewDir, lightDir, metallic, roughness, 1)
+					if c.x < 0 || c.y < 0 || c.z < 0 {
+						t.Fatalf("Unexpected negative reflection %v, metallic %g, roughness %g.", c, metallic, roughness)
+					}
+					sum += c.x / pi
+				}
+				// Uniform hemisphere sampling: the integral is the mean times 2pi.
+				// A few percent of slack for the sampling noise and the approximate split between the diffuse and the specular.
+				if albedo := sum / samples * 2 * math.Pi; albedo > 1.05 {
+					t.Errorf("Unexpected albedo %g for the view %v, metallic %g, roughness %g, expected at most 1.", albedo, viewDir, metallic, roughness)
+				}
+			}
+		}
+	}
+}
+
+// TestCookTorranceBackSide checks no light is reflected from behind the surface or toward it.
+func TestCookTorranceBackSide(t *testing.T) {
+	t.Parallel()
+
+	normal, up, down := newVec3(0, 1, 0), newVec3(0, 1, 0), normalize3(newVec3(1, -1, 0))
+	white := newVec4(1, 1, 1, 1)
+	for _, tc := range []struct {
+		name              string
+		viewDir, lightDir vec3
+	}{
+		{"light behind", up, down},
+		{"view behind", down, up},
+	} {
+		if got := cookTorrance(white, normal, tc.viewDir, tc.lightDir, 0, 0.5, 1); got.xyz != newVec3(0, 0, 0) {
+			t.Errorf("%s: unexpected reflection %v, expected none.", tc.name, got)
+		}
+	}
+}
+
+// TestFresnelSchlick checks the reflectance goes from the base one at normal incidence to white at grazing angles.
+func TestFresnelSchlick(t *testing.T) {
+	t.Parallel()
+
+	f0 := specularColor(newVec4(1, 0.5, 0, 1), 0)
+	if want := newVec3(dielectricReflectance, dielectricReflectance, dielectricReflectance); !approxVec3(f0.xyz, want, 1e-9) {
+		t.Errorf("Unexpected dielectric reflectance %v, expected %v.", f0, want)
+	}
+	gold := specularColor(newVec4(1, 0.8, 0.3, 1), 1)
+	if got := fresnelSchlick(gold, 1); !approxVec3(got.xyz, gold.xyz, 1e-9) {
+		t.Errorf("Unexpected reflectance %v at normal incidence, expected %v.", got, gold)
+	}
+	if got := fresnelSchlick(gold, 0); !approxVec3(got.xyz, newVec3(1, 1, 1), 1e-9) {
+		t.Errorf("Unexpected reflectance %v at grazing incidence, expected white.", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// quarticFromRoots returns the coefficients of (x-r0)(x-r1)(x-r2)(x-r3) = x^4 + a*x^3 + b*x^2 + c*x + d.
+func quarticFromRoots(r [4]float) (a, b, c, d float) {
+	a = -(r[0] + r[1] + r[2] + r[3])
+	b = r[0]*r[1] + r[0]*r[2] + r[0]*r[3] + r[1]*r[2] + r[1]*r[3] + r[2]*r[3]
+	c = -(r[0]*r[1]*r[2] + r[0]*r[1]*r[3] + r[0]*r[2]*r[3] + r[1]*r[2]*r[3])
+	d = r[0] * r[1] * r[2] * r[3]
+	return a, b, c, d
+}
+
+func TestFirstQuarticRoot(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		roots  [4]float
+		lo, hi float
+		// Accepted roots. A double root, where the polynomial touches zero without crossing, may be missed,
+		// the next root is accepted then. Empty when there is no root in the range.
+		want []float
+		// The only root in the range is a double one, it may be missed.
+		mayMiss bool
+	}{
+		{name: "simple roots", roots: [4]float{1, 2, 3, 4}, lo: 0, hi: 10, want: []float{1}},
+		{name: "unordered roots", roots: [4]float{4, 3, 1, 2}, lo: 0, hi: 10, want: []float{1}},
+		{name: "roots before lo", roots: [4]float{1, 2, 3, 4}, lo: 2.5, hi: 10, want: []float{3}},
+		{name: "roots after hi", roots: [4]float{1, 2, 3, 4}, lo: 0, hi: 0.5},
+		{name: "root on lo", roots: [4]float{1, 2, 3, 4}, lo: 2, hi: 10, want: []float{2}},
+		{name: "negative roots", roots: [4]float{-4, -3, -2, -1}, lo: 0, hi: 10},
+		{name: "close roots", roots: [4]float{1, 1.001, 3, 4}, lo: 0, hi: 10, want: []float{1}},
+		{name: "triple root", roots: [4]float{1, 1, 1, 3}, lo: 0, hi: 10, want: []float{1}},
+		{name: "double root first", roots: [4]float{2, 2, 5, 6}, lo: 0, hi: 10, want: []float{2, 5}},
+		{name: "double root in the middle", roots: [4]float{1, 2, 2, 4}, lo: 1.5, hi: 10, want: []float{2, 4}},
+		{name: "only a double root", roots: [4]float{-3, -1, 2, 2}, lo: 0, hi: 10, want: []float{2}, mayMiss: true},
+		{name: "two double roots", roots: [4]float{1, 1, 3, 3}, lo: 0, hi: 10, want: []float{1, 3}, mayMiss: true},
+		{name: "quadruple root", roots: [4]float{0.5, 0.5, 0.5, 0.5}, lo: 0, hi: 10, want: []float{0.5}, mayMiss: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b, c, d := quarticFromRoots(tc.roots)
+			root, ok := firstQuarticRoot(a, b, c, d, tc.lo, tc.hi)
+			if !ok {
+				if len(tc.want) > 0 && !tc.mayMiss {
+					t.Fatalf("No root found, expected %v.", tc.want[0])
+				}
+				return
+			}
+			if len(tc.want) == 0 {
+				t.Fatalf("Unexpected root %v, expected none.", root)
+			}
+			for _, elem := range tc.want {
+				if math.Abs(root-elem) < 1e-5 {
+					return
+				}
+			}
+			t.Fatalf("Unexpected root %v, expected one of %v.", root, tc.want)
+		})
+	}
+}
+
+func TestFirstQuarticRootNoRealRoot(t *testing.T) {
+	t.Parallel()
+
+	// (x^2+1)(x^2+4).
+	if root, ok := firstQuarticRoot(0, 5, 0, 4, -10, 10); ok {
+		t.Fatalf("Unexpected root %v, expected none.", root)
+	}
+}
+
+func TestHitTorus(t *testing.T) {
+	t.Parallel()
+
+	// Around the y axis, the tube from 0.75 to 1.25 from the axis and from -0.25 to 0.25 in y.
+	torus := newTorus(newVec3(0, 0, 0), newVec3(0, 1, 0), 1, 0.25, 0)
+
+	for _, tc := range []struct {
+		name             string
+		start, dir       vec3
+		want             float
+		tangent          bool // The ray touches the torus without entering it, it may be missed.
+		minDist, maxDist float
+	}{
+		{name: "through the tube", start: newVec3(-5, 0, 0), dir: newVec3(1, 0, 0), want: 3.75},
+		{name: "scaled direction", start: newVec3(-5, 0, 0), dir: newVec3(2, 0, 0), want: 1.875},
+		{name: "from the hole", start: newVec3(0, 0, 0), dir: newVec3(1, 0, 0), want: 0.75},
+		{name: "from inside the tube", start: newVec3(1, 0.1, 0), dir: newVec3(1, 0, 0), want: math.Sqrt(0.0525)},
+		{name: "along the axis", start: newVec3(0, 5, 0), dir: newVec3(0, -1, 0)},
+		{name: "above", start: newVec3(-5, 0.26, 0), dir: newVec3(1, 0, 0)},
+		{name: "grazing the top", start: newVec3(-5, 0.2499, 0), dir: newVec3(1, 0, 0), want: 3.9929},
+		{name: "tangent to the top", start: newVec3(-5, 0.25, 0), dir: newVec3(1, 0, 0), want: 4, tangent: true},
+		{name: "tangent to the outside", start: newVec3(1.25, 0, -5), dir: newVec3(0, 0, 1), want: 5, tangent: true},
+		{name: "through the inner equator", start: newVec3(0.75, 0, -5), dir: newVec3(0, 0, 1), want: 4},
+		{name: "beside", start: newVec3(1.26, 0, -5), dir: newVec3(0, 0, 1)},
+		{name: "behind", start: newVec3(5, 0, 0), dir: newVec3(1, 0, 0)},
+		{name: "beyond max distance", start: newVec3(-5, 0, 0), dir: newVec3(1, 0, 0), maxDist: 3},
+		{name: "after min distance", start: newVec3(-5, 0, 0), dir: newVec3(1, 0, 0), minDist: 4, want: 4.25},
+		{name: "second tube", start: newVec3(-5, 0, 0), dir: newVec3(1, 0, 0), minDist: 4.5, want: 5.75},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			minDist, maxDist := max(tc.minDist, 0.0001), tc.maxDist
+			if maxDist == 0 {
+				maxDist = -1
+			}
+			got := hitTorus(tc.start, tc.dir, torus, minDist, maxDist)
+			if got == 0 && tc.tangent {
+				return
+			}
+			if math.Abs(got-tc.want) > 1e-3 {
+				t.Fatalf("Unexpected distance %v, expected %v.", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// TestRandom checks the numbers are in [0, 1), roughly uniform, and fit the 32 bits Kage ints.
+func TestRandom(t *testing.T) {
+	t.Parallel()
+
+	var buckets [10]int
+	const n = 100000
+	for i := range n {
+		seed := hashSeed(i*7919, i)
+		if seed < 0 || seed > 0x7fffffff {
+			t.Fatalf("Unexpected seed %d out of the 31 bits range.", seed)
+		}
+		r := random(seed)
+		if r < 0 || r >= 1 {
+			t.Fatalf("Unexpected random %g out of [0, 1).", r)
+		}
+		buckets[int(r*10)]++
+	}
+	for i, count := range buckets {
+		if count < n/10*9/10 || count > n/10*11/10 {
+			t.Errorf("Unexpected bucket %d with %d numbers, expected about %d.", i, count, n/10)
+		}
+	}
+
+	// Negative and 64 bits inputs end up in the same 31 bits as in Kage.
+	if hash(-1) != hash(0x7fffffff) || hash(1<<40|5) != hash(5) {
+		t.Error("Unexpected hash of the bits above 31.")
+	}
+}
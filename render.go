@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file holds the headless rendering logic.
+// It doesn't depend on Ebiten so it can run without a window (CI, build servers, etc).
+
+// Renderer renders a scene on the CPU using the shader code.
+// It carries everything the shader gets from uniforms and injected constructors,
+// so several renderers can be used concurrently.
+type Renderer struct {
+	scene      sceneData
+	camera     camera
+	resolution vec2
+	depth      int
+}
+
+// newRenderer creates a renderer for the given scene and resolution.
+// depth is the max number of bounces, up to maxDepth.
+func newRenderer(s scene, width, height, depth int) *Renderer {
+	return &Renderer{
+		scene:      s.data,
+		camera:     s.Camera,
+		resolution: vec2{float(width), float(height)},
+		depth:      depth,
+	}
+}
+
+// pixel computes the color of the given pixel.
+func (r *Renderer) pixel(x, y int) vec4 {
+	return renderPixel(x, y, r.resolution, r.camera.Origin, r.camera.LookAt, r.depth, r.scene.things, r.scene.bvh, r.scene.lights, r.scene.materials, r.scene.textures, r.scene.ambientLight)
+}
+
+// tileSize is the size in pixels of the square tiles the frame is split into.
+const tileSize = 32
+
+// tile is a part of the frame rendered by a single worker.
+type tile struct {
+	bounds   image.Rectangle
+	duration time.Duration
+}
+
+// renderStats summarizes the timing of the rendered tiles.
+type renderStats struct {
+	start        time.Time
+	tiles, total int
+	elapsed      time.Duration // Wall clock time up to the last rendered tile.
+	busy         time.Duration // Sum of the tiles render time, across workers.
+	slowest      tile
+}
+
+// add records a rendered tile.
+func (s *renderStats) add(t tile) {
+	s.tiles++
+	s.elapsed = time.Since(s.start)
+	s.busy += t.duration
+	if t.duration > s.slowest.duration {
+		s.slowest = t
+	}
+}
+
+// done returns true when all the tiles got rendered.
+func (s renderStats) done() bool {
+	return s.tiles == s.total
+}
+
+func (s renderStats) String() string {
+	if s.tiles == 0 {
+		return fmt.Sprintf("0/%d tiles", s.total)
+	}
+	return fmt.Sprintf("%d/%d tiles in %s, avg tile: %s, slowest tile: %s at %v",
+		s.tiles, s.total, s.elapsed.Round(time.Millisecond),
+		(s.busy / time.Duration(s.tiles)).Round(time.Microsecond),
+		s.slowest.duration.Round(time.Microsecond), s.slowest.bounds.Min)
+}
+
+// renderJob is a frame being rendered by a pool of workers.
+// Each worker only writes to the pixels of its own tile and a tile is sent on the channel
+// once done, so the consumer can read the pixels of the received tiles while the others are in flight.
+type renderJob struct {
+	img    *image.RGBA
+	tiles  chan tile // Closed when all the workers are done, including on cancel.
+	cancel context.CancelFunc
+	stats  renderStats // Owned by the consumer.
+}
+
+// start renders the frame in the background, split in tiles across runtime.NumCPU() workers.
+func (r *Renderer) start(ctx context.Context) *renderJob {
+	ctx, cancel := context.WithCancel(ctx)
+	width, height := int(r.resolution.x), int(r.resolution.y)
+
+	var rects []image.Rectangle
+	for y := 0; y < height; y += tileSize {
+		for x := 0; x < width; x += tileSize {
+			rects = append(rects, image.Rect(x, y, min(x+tileSize, width), min(y+tileSize, height)))
+		}
+	}
+	queue := make(chan image.Rectangle, len(rects))
+	for _, elem := range rects {
+		queue <- elem
+	}
+	close(queue)
+
+	job := &renderJob{
+		img:    image.NewRGBA(image.Rect(0, 0, width, height)),
+		tiles:  make(chan tile, len(rects)), // Buffered so the workers never block on a slow consumer.
+		cancel: cancel,
+		stats:  renderStats{start: time.Now(), total: len(rects)},
+	}
+
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rect := range queue {
+				now := time.Now()
+				if !r.renderTile(ctx, job.img, rect) {
+					return
+				}
+				job.tiles <- tile{bounds: rect, duration: time.Since(now)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(job.tiles)
+	}()
+
+	return job
+}
+
+// renderTile renders the given part of the frame into img.
+// Returns false if the context got cancelled before the end.
+func (r *Renderer) renderTile(ctx context.Context, img *image.RGBA, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		if ctx.Err() != nil {
+			return false
+		}
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c0 := r.pixel(x, y)
+			off := img.PixOffset(x, y)
+			img.Pix[off+0] = uint8(min(255, c0.x*255))
+			img.Pix[off+1] = uint8(min(255, c0.y*255))
+			img.Pix[off+2] = uint8(min(255, c0.z*255))
+			img.Pix[off+3] = uint8(255)
+		}
+	}
+	return true
+}
+
+// poll returns the next rendered tile, if any, without blocking.
+func (j *renderJob) poll() (tile, bool) {
+	select {
+	case t, ok := <-j.tiles:
+		if ok {
+			j.stats.add(t)
+		}
+		return t, ok
+	default:
+		return tile{}, false
+	}
+}
+
+// wait blocks until all the tiles got rendered or the job got cancelled.
+func (j *renderJob) wait() {
+	for t := range j.tiles {
+		j.stats.add(t)
+	}
+}
+
+// render the whole frame.
+func (r *Renderer) render() (*image.RGBA, renderStats) {
+	job := r.start(context.Background())
+	job.wait()
+	job.cancel() // Release the context.
+	return job.img, job.stats
+}
+
+// vec3Flag implements flag.Value to parse a "x,y,z" vector from the command line.
+type vec3Flag struct {
+	v   vec3
+	set bool
+}
+
+func (f *vec3Flag) String() string {
+	if !f.set {
+		return ""
+	}
+	return fmt.Sprintf("%g,%g,%g", f.v.x, f.v.y, f.v.z)
+}
+
+func (f *vec3Flag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid vector %q, expected x,y,z", s)
+	}
+	var arr [3]float
+	for i, elem := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(elem), 64)
+		if err != nil {
+			return fmt.Errorf("invalid vector component %q: %w", elem, err)
+		}
+		arr[i] = n
+	}
+	f.v = newVec3(arr[0], arr[1], arr[2])
+	f.set = true
+	return nil
+}
+
+// runRender implements the `render` sub command.
+// It renders a single frame of the given scene on the CPU and writes it as a PNG.
+//
+// Example:
+//
+//	rtv1 render -scene scenes/a.json -w 1920 -h 1080 -o out.png
+func runRender(args []string) error {
+	var (
+		sceneName     string
+		width, height int
+		depth         int
+		output        string
+		origin        vec3Flag
+		lookAt        vec3Flag
+	)
+
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.StringVar(&sceneName, "scene", "", "Scene file to render. Defaults to the first embedded scene.")
+	flags.IntVar(&width, "w", initialScreenWidth, "Width of the output image.")
+	flags.IntVar(&height, "h", initialScreenHeight, "Height of the output image.")
+	flags.StringVar(&output, "o", "out.png", "Output PNG file.")
+	flags.IntVar(&depth, "depth", maxDepth, fmt.Sprintf("Max number of bounces, up to %d. At most %d rays are traced per pixel, whatever the depth.", maxDepth, maxTraceRays))
+	flags.Var(&origin, "origin", "Override the camera origin (x,y,z).")
+	flags.Var(&lookAt, "lookat", "Override the camera lookAt (x,y,z).")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %q", flags.Args())
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	if depth < 0 || depth > maxDepth {
+		return fmt.Errorf("invalid depth %d, expected 0 to %d", depth, maxDepth)
+	}
+
+	scenes, sceneIdx, err := openSceneDir(sceneName)
+	if err != nil {
+		return fmt.Errorf("open scene: %w", err)
+	}
+	s, err := scenes.load(sceneIdx)
+	if err != nil {
+		return fmt.Errorf("load scene: %w", err)
+	}
+	if origin.set {
+		s.Camera.Origin = origin.v
+	}
+	if lookAt.set {
+		s.Camera.LookAt = lookAt.v
+	}
+	if s.Camera.Origin == s.Camera.LookAt {
+		return fmt.Errorf("camera origin and lookAt must be different")
+	}
+
+	img, stats := newRenderer(s, width, height, depth).render()
+	fmt.Fprintf(os.Stderr, "Rendered %s.\n", stats)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		_ = f.Close() // Best effort.
+		return fmt.Errorf("encode png: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close output file: %w", err)
+	}
+
+	return nil
+}
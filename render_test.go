@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestScene loads one of the embedded scenes.
+func loadTestScene(t *testing.T, name string) scene {
+	t.Helper()
+	fsys, err := fs.Sub(sceneFiles, "scenes")
+	if err != nil {
+		t.Fatalf("Failed to open the embedded scenes: %s.", err)
+	}
+	s, err := loadScene(fsys, name)
+	if err != nil {
+		t.Fatalf("Failed to load %s: %s.", name, err)
+	}
+	return s
+}
+
+// TestRenderConcurrent renders different scenes at the same time and checks each matches its own
+// sequential render, the scenes don't share any state.
+func TestRenderConcurrent(t *testing.T) {
+	t.Parallel()
+
+	fsys, err := fs.Sub(sceneFiles, "scenes")
+	if err != nil {
+		t.Fatalf("Failed to open the embedded scenes: %s.", err)
+	}
+
+	const width, height = 64, 48
+
+	// Different objects, lights and materials.
+	names := []string{"a.json", "spheres_checkerboard_colored_lights.json", "spheres_checkerboard_white_light.json"}
+	scenes := make([]scene, len(names))
+	want := make([][]byte, len(names))
+	for i, name := range names {
+		s, err := loadScene(fsys, name)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %s.", name, err)
+		}
+		img, _ := newRenderer(s, width, height, maxDepth).render()
+		scenes[i], want[i] = s, img.Pix
+	}
+	for i := 1; i < len(want); i++ {
+		if bytes.Equal(want[0], want[i]) {
+			t.Fatalf("Unexpected identical renders of %s and %s.", names[0], names[i])
+		}
+	}
+
+	for run := range 2 {
+		for i, name := range names {
+			t.Run(fmt.Sprintf("%s#%d", name, run), func(t *testing.T) {
+				t.Parallel()
+
+				img, _ := newRenderer(scenes[i], width, height, maxDepth).render()
+				if !bytes.Equal(img.Pix, want[i]) {
+					t.Fatalf("Unexpected render of %s, different from the sequential one.", name)
+				}
+			})
+		}
+	}
+}
+
+// TestRenderTiles checks the tiles cover the frame exactly once, including the partial ones on the edges,
+// and the tiled render matches the pixel by pixel one.
+func TestRenderTiles(t *testing.T) {
+	t.Parallel()
+
+	s := loadTestScene(t, "a.json")
+	const width, height = 2*tileSize + 5, tileSize + 1
+
+	r := newRenderer(s, width, height, maxDepth)
+	job := r.start(context.Background())
+	defer job.cancel()
+
+	covered := make([]int, width*height)
+	for done := range job.tiles {
+		job.stats.add(done)
+		for y := done.bounds.Min.Y; y < done.bounds.Max.Y; y++ {
+			for x := done.bounds.Min.X; x < done.bounds.Max.X; x++ {
+				covered[y*width+x]++
+			}
+		}
+	}
+	for i, n := range covered {
+		if n != 1 {
+			t.Fatalf("Unexpected pixel %d,%d rendered %d times.", i%width, i/width, n)
+		}
+	}
+	if !job.stats.done() || job.stats.total != 3*2 {
+		t.Errorf("Unexpected stats %s, expected 6/6 tiles.", job.stats)
+	}
+
+	expect := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			c := r.pixel(x, y)
+			off := expect.PixOffset(x, y)
+			expect.Pix[off+0] = uint8(min(255, c.x*255))
+			expect.Pix[off+1] = uint8(min(255, c.y*255))
+			expect.Pix[off+2] = uint8(min(255, c.z*255))
+			expect.Pix[off+3] = 255
+		}
+	}
+	if !bytes.Equal(job.img.Pix, expect.Pix) {
+		t.Error("Unexpected tiled render, different from the pixel by pixel one.")
+	}
+}
+
+// TestRenderCancel checks a cancelled render stops its workers, closing the tiles channel early.
+func TestRenderCancel(t *testing.T) {
+	t.Parallel()
+
+	s := loadTestScene(t, "a.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job := newRenderer(s, 1920, 1080, maxDepth).start(ctx)
+	job.wait()
+	if job.stats.done() {
+		t.Errorf("Unexpected complete render after cancel: %s.", job.stats)
+	}
+	if _, ok := job.poll(); ok {
+		t.Error("Unexpected tile polled after the end of the render.")
+	}
+}
+
+// TestRunRender runs the render command end to end, from the scene file on disk to the PNG file,
+// and checks the image matches the CPU render with the camera override.
+func TestRunRender(t *testing.T) {
+	t.Parallel()
+
+	output := filepath.Join(t.TempDir(), "out.png")
+	if err := runRender([]string{"-scene", "scenes/a.json", "-w", "40", "-h", "30", "-depth", "3", "-origin", "0,1,8", "-o", output}); err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Failed to open the output: %s.", err)
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("Failed to decode the output: %s.", err)
+	}
+	got := image.NewRGBA(img.Bounds())
+	draw.Draw(got, got.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	s := loadTestScene(t, "a.json")
+	s.Camera.Origin = newVec3(0, 1, 8)
+	want, _ := newRenderer(s, 40, 30, 3).render()
+	if got.Bounds() != want.Bounds() || !bytes.Equal(got.Pix, want.Pix) {
+		t.Errorf("Unexpected output %v, different from the %v CPU render.", got.Bounds(), want.Bounds())
+	}
+}
+
+func TestRunRenderErrors(t *testing.T) {
+	t.Parallel()
+
+	output := filepath.Join(t.TempDir(), "out.png")
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"invalid size", []string{"-w", "0"}, "invalid size 0x600"},
+		{"invalid depth", []string{"-depth", "99"}, "invalid depth 99"},
+		{"extra argument", []string{"scenes/a.json"}, "unexpected arguments"},
+		{"missing scene", []string{"-scene", "scenes/missing.json"}, "open scene"},
+		{"same camera points", []string{"-origin", "1,2,3", "-lookat", "1,2,3"}, "camera origin and lookAt must be different"},
+		{"missing output directory", []string{"-w", "4", "-h", "4", "-o", filepath.Join(output, "sub", "out.png")}, "create output file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := runRender(append([]string{"-o", output}, tc.args...))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Unexpected error %v, expected %q.", err, tc.want)
+			}
+		})
+	}
+}
@@ -2,61 +2,62 @@ package main
 
 import (
 	"embed"
-	"encoding/json"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
-//go:embed scenes/*.json
+//go:embed scenes/*.json scenes/*.obj scenes/*.mtl scenes/*.png scenes/*.jpg
 var sceneFiles embed.FS
 
-type objects []any
+// sceneObject is implemented by all the scene object types.
+type sceneObject interface {
+	// validate the object fields, path is the location of the object in the scene file.
+	validate(v *sceneValidator, path string)
+	// things returns the encoded object, most objects are a single thing, meshes are many.
+	things() ThingsT
+}
+
+type objects []sceneObject
 
-var factory = map[string]func() any{
-	"plane": func() any {
+var factory = map[string]func() sceneObject{
+	"plane": func() sceneObject {
 		return &plane{}
 	},
-	"sphere": func() any {
+	"sphere": func() sceneObject {
 		return &sphere{}
 	},
-	"cylinder": func() any {
+	"cylinder": func() sceneObject {
 		return &cylinder{}
 	},
-	"cone": func() any {
+	"cone": func() sceneObject {
 		return &cone{}
 	},
-}
-
-func (objs *objects) UnmarshalJSON(data []byte) error {
-	var arr []json.RawMessage
-	if err := json.Unmarshal(data, &arr); err != nil {
-		return fmt.Errorf("failed to unmarshal objects: %w", err)
-	}
-
-	*objs = make(objects, len(arr))
-	for i, obj := range arr {
-		// Extract the type of the object first.
-		var tmp struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(obj, &tmp); err != nil {
-			return fmt.Errorf("failed to unmarshal object type: %w", err)
-		}
-
-		// Then instantiate the object using the factory.
-		if _, ok := factory[tmp.Type]; !ok {
-			return fmt.Errorf("unknown object type: %q", tmp.Type)
-		}
-		target := factory[tmp.Type]()
-
-		// Finally unmarshal into the final object.
-		if err := json.Unmarshal(obj, target); err != nil {
-			return fmt.Errorf("failed to unmarshal object %q: %w", tmp.Type, err)
-		}
-
-		(*objs)[i] = target
-	}
-
-	return nil
+	"triangle": func() sceneObject {
+		return &triangle{}
+	},
+	"mesh": func() sceneObject {
+		return &mesh{}
+	},
+	"csg": func() sceneObject {
+		return &csg{}
+	},
+	"torus": func() sceneObject {
+		return &torus{}
+	},
+	"box": func() sceneObject {
+		return &box{}
+	},
+	"quad": func() sceneObject {
+		return &quad{}
+	},
+	"disc": func() sceneObject {
+		return &disc{}
+	},
 }
 
 type scene struct {
@@ -66,99 +67,123 @@ type scene struct {
 	AmbientLight light      `json:"ambient_light"`
 	Lights       []light    `json:"lights"`
 	Materials    []material `json:"materials"`
+
+	textures []texture // Of the materials.
+	data     sceneData // Encoded scene, set at load time.
+}
+
+// sceneDir lists the scene files available in a directory.
+type sceneDir struct {
+	fsys  fs.FS
+	names []string
+}
+
+// readSceneDir lists the json scene files at the root of the given filesystem.
+func readSceneDir(fsys fs.FS) (sceneDir, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return sceneDir{}, fmt.Errorf("failed to list scene files: %w", err)
+	}
+	return sceneDir{fsys: fsys, names: names}, nil
 }
 
-func loadScene(fileName string) (scene, error) {
-	if fileName == "" { // If filename is empty, lookup the first scene.
-		files, err := sceneFiles.ReadDir("scenes")
+// openSceneDir resolves the given path to a scene directory and the index of the requested scene in it.
+// The path can be a scene file or a directory on disk. If it doesn't exist on disk,
+// it is looked up in the embedded scenes. If empty, the first embedded scene is used.
+func openSceneDir(p string) (sceneDir, int, error) {
+	var (
+		fsys fs.FS
+		name string
+	)
+	if fi, err := os.Stat(p); err != nil {
+		// Fallback to the embedded scenes.
+		sub, err := fs.Sub(sceneFiles, "scenes")
 		if err != nil {
-			return scene{}, fmt.Errorf("failed to read scenes directory: %w", err)
+			return sceneDir{}, 0, fmt.Errorf("failed to open embedded scenes: %w", err)
 		}
-		if len(files) == 0 {
-			return scene{}, fmt.Errorf("no scene files found")
-		}
-		fileName = files[0].Name()
+		fsys, name = sub, strings.TrimPrefix(filepath.ToSlash(p), "scenes/")
+	} else if fi.IsDir() {
+		fsys = os.DirFS(p)
+	} else {
+		fsys, name = os.DirFS(filepath.Dir(p)), filepath.Base(p)
 	}
 
-	// Load the file content.
-	buf, err := sceneFiles.ReadFile("scenes/" + fileName)
+	dir, err := readSceneDir(fsys)
 	if err != nil {
-		return scene{}, fmt.Errorf("failed to read scene.json: %w", err)
+		return sceneDir{}, 0, err
 	}
 
-	// Reset the material index.
-	materialTypeIndex = map[string]int{}
-
-	// Parse the json. Most of the logic is in scene.UnmarshalJSON.
-	var s scene
-	if err := json.Unmarshal(buf, &s); err != nil {
-		return scene{}, fmt.Errorf("failed to unmarshal scene: %w", err)
+	// No specific scene requested, use the first one.
+	if name == "" {
+		if len(dir.names) == 0 {
+			return sceneDir{}, 0, fmt.Errorf("no scene files found in %q", p)
+		}
+		return dir, 0, nil
 	}
-	s.name = fileName
 
-	// Update the scene global variables to pass them to the Fragment function.
-	sceneObjects = make(ThingsT, 0, len(s.Objects))
-	for _, elem := range s.Objects {
-		sceneObjects = append(sceneObjects, elem.(interface{ mat4() mat4 }).mat4())
-	}
-	sceneLights = make(LightsT, 0, len(s.Lights))
-	for _, elem := range s.Lights {
-		sceneLights = append(sceneLights, elem.mat4())
+	if idx := slices.Index(dir.names, name); idx != -1 {
+		return dir, idx, nil
 	}
-	sceneMaterials = make(MaterialsT, 0, len(s.Materials))
-	for _, elem := range s.Materials {
-		sceneMaterials = append(sceneMaterials, elem.mat4())
+	// The requested file may not have the .json extension, add it to the list if it exists.
+	if _, err := fs.Stat(fsys, name); err != nil {
+		return sceneDir{}, 0, fmt.Errorf("scene %q not found", p)
 	}
-	ambientLight = s.AmbientLight.mat4()
+	dir.names = append(dir.names, name)
+	return dir, len(dir.names) - 1, nil
+}
 
-	return s, nil
+// load the scene at the given index.
+func (d sceneDir) load(idx int) (scene, error) {
+	return loadScene(d.fsys, d.names[idx])
 }
 
-// Generate objects the constructors for the shader to compile.
-//
-// Example:
-//
-//	sceneObjects := ThingsT{
-//	  newPlane(newVec3(0.000000, 1.000000, 0.000000), 0.000000, newVec4(1.000000, 1.000000, 1.000000, 1.000000)),
-//	  newSphere(newVec3(0.000000, 1.000000, -0.250000), 1.000000, newVec4(1.000000, 1.000000, 0.000000, 1.000000)),
-//	  newSphere(newVec3(-1.000000, 0.500000, 1.500000), 0.500000, newVec4(1.000000, 0.000000, 0.000000, 1.000000)),
-//	}
-func (s scene) marshalInjectThings() string {
-	injectThings := "sceneObjects := ThingsT{\n"
-	for _, obj := range s.Objects {
-		injectThings += "\t\t" + obj.(interface{ marshalConstructor() string }).marshalConstructor() + ",\n"
+func loadScene(fsys fs.FS, fileName string) (scene, error) {
+	// Load the file content.
+	buf, err := fs.ReadFile(fsys, fileName)
+	if err != nil {
+		return scene{}, fmt.Errorf("failed to read %s: %w", fileName, err)
 	}
-	injectThings += "\t}\n"
 
-	return injectThings
-}
+	// Files referenced by the scene, like meshes, are relative to the scene file.
+	sceneFS, err := fs.Sub(fsys, path.Dir(fileName))
+	if err != nil {
+		return scene{}, fmt.Errorf("failed to open scene directory: %w", err)
+	}
 
-// Generate the lights constructors for the shader to compile.
-//
-// Example:
-//
-//	sceneLights := LightsT{
-//	  newLight(newVec3(-2.000000, 2.500000, 0.000000), newVec4(0.490000, 0.070000, 0.070000, 1.000000)),
-//	  newLight(newVec3(1.500000, 2.500000, 1.500000), newVec4(0.070000, 0.070000, 0.490000, 1.000000)),
-//	  newLight(newVec3(1.500000, 2.500000, -1.500000), newVec4(0.070000, 0.490000, 0.070000, 1.000000)),
-//	  newLight(newVec3(0.000000, 3.500000, -1.500000), newVec4(0.210000, 0.210000, 0.350000, 1.000000)),
-//	}
-func (s scene) marshalInjectLights() string {
-	injectLights := "sceneLights := LightsT{\n"
-	for _, obj := range s.Lights {
-		injectLights += "\t\t" + obj.marshalConstructor() + ",\n"
+	// Parse the json. Most of the logic is in parseScene.
+	s, err := parseScene(sceneFS, buf)
+	if err != nil {
+		return scene{}, fmt.Errorf("invalid scene:\n%w", err)
+	}
+	s.name = fileName
+	if s.data, err = newSceneData(s); err != nil {
+		return scene{}, err
 	}
-	injectLights += "\t}\n"
 
-	return injectLights
+	return s, nil
 }
 
-func (s scene) marshalInjectMaterials() string {
-	injectMaterials := "sceneMaterials := MaterialsT{\n"
-	for _, obj := range s.Materials {
-		injectMaterials += "\t\t" + obj.marshalConstructor() + ",\n"
+// newSceneData encodes the scene to be passed to the shader code.
+func newSceneData(s scene) (sceneData, error) {
+	data := sceneData{
+		things:       make(ThingsT, 0, len(s.Objects)),
+		lights:       make(LightsT, 0, len(s.Lights)),
+		materials:    make(MaterialsT, 0, len(s.Materials)),
+		textures:     newTextures(s.textures),
+		ambientLight: s.AmbientLight.mat4(),
 	}
-	injectMaterials += "\t}\n"
-
-	return injectMaterials
+	for _, elem := range s.Objects {
+		data.things = append(data.things, elem.things()...)
+	}
+	data.things, data.bvh = buildBVH(data.things)
+	for _, elem := range s.Lights {
+		data.lights = append(data.lights, elem.mat4())
+	}
+	for _, elem := range s.Materials {
+		data.materials = append(data.materials, elem.mat4())
+	}
+	if err := packSceneData(&data); err != nil {
+		return sceneData{}, err
+	}
+	return data, nil
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// This file holds the scene parsing and validation logic.
+// Every problem is reported with its JSON path and line, and all of them are reported at once.
+//
+// Example:
+//
+//	objects[3].material: unknown material "gold" (line 42)
+
+// sceneError is a validation error located in the scene file.
+type sceneError struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e *sceneError) Error() string {
+	if e.path == "" {
+		return fmt.Sprintf("%s (line %d)", e.msg, e.line)
+	}
+	return fmt.Sprintf("%s: %s (line %d)", e.path, e.msg, e.line)
+}
+
+// jsonLines maps the paths of a JSON document to their line number.
+// Paths are formatted as `objects[3].material`, the root being "".
+type jsonLines map[string]int
+
+// indexJSONLines walks the given JSON document and records the line of each path.
+// For object fields, the line is the one of the key.
+func indexJSONLines(buf []byte) (jsonLines, error) {
+	lines := jsonLines{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+
+	// The decoder offset points right after the previous token, skip the separators to get the line of the next one.
+	lineAt := func(off int64) int {
+		for off < int64(len(buf)) && strings.IndexByte(" \t\r\n,:", buf[off]) != -1 {
+			off++
+		}
+		return bytes.Count(buf[:off], []byte("\n")) + 1
+	}
+
+	var walk func(path string, line int) error
+	walk = func(path string, line int) error {
+		lines[path] = line
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				keyLine := lineAt(dec.InputOffset())
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name, _ := key.(string) // Keys are always strings.
+				if err := walk(joinPath(path, name), keyLine); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i), lineAt(dec.InputOffset())); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	}
+
+	if err := walk("", 1); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// The offset is right after the invalid character.
+			return nil, &sceneError{line: bytes.Count(buf[:max(0, syntaxErr.Offset-1)], []byte("\n")) + 1, msg: syntaxErr.Error()}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, &sceneError{line: 1, msg: "empty document"}
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, &sceneError{line: lineAt(dec.InputOffset()), msg: "unexpected end of JSON input"}
+		}
+		return nil, &sceneError{line: lineAt(dec.InputOffset()), msg: err.Error()}
+	}
+	// The decoder stops after the first value, anything but spaces after it is an error.
+	line := lineAt(dec.InputOffset())
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, &sceneError{line: line, msg: "unexpected data after the end of the document"}
+	}
+	return lines, nil
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// sceneValidator collects the scene errors.
+type sceneValidator struct {
+	fsys      fs.FS // Files referenced by the scene, relative to the scene file.
+	lines     jsonLines
+	invalid   map[string]bool // Paths which failed to decode.
+	materials map[string]int
+	defined   []material     // Materials of the scene file.
+	added     []material     // Materials added while loading the objects, appended to the scene ones.
+	addedKeys map[string]int // Indices of the added materials, by the key given to addMaterial.
+	textures  []texture      // Of the materials, in the order of their textureIdx.
+	errs      []*sceneError
+}
+
+// line returns the line of the given path.
+// If the path doesn't exist in the document (i.e. missing field), the line of the closest parent is used.
+func (v *sceneValidator) line(path string) int {
+	for {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+		idx := strings.LastIndexAny(path, ".[")
+		if idx == -1 {
+			return 1
+		}
+		path = path[:idx]
+	}
+}
+
+// errorf records an error for the given path.
+func (v *sceneValidator) errorf(path, format string, args ...any) {
+	v.errs = append(v.errs, &sceneError{path: path, line: v.line(path), msg: fmt.Sprintf(format, args...)})
+}
+
+// has returns true if the given path is present in the document.
+func (v *sceneValidator) has(path string) bool {
+	_, ok := v.lines[path]
+	return ok
+}
+
+// ok returns true if the given path is present in the document and got decoded successfully.
+func (v *sceneValidator) ok(path string) bool {
+	return v.has(path) && !v.invalid[path]
+}
+
+// err returns all the recorded errors, sorted by line.
+func (v *sceneValidator) err() error {
+	slices.SortStableFunc(v.errs, func(a, b *sceneError) int { return a.line - b.line })
+	errs := make([]error, 0, len(v.errs))
+	for _, elem := range v.errs {
+		errs = append(errs, elem)
+	}
+	return errors.Join(errs...)
+}
+
+// require records an error for each of the given fields missing from the object at the given path.
+func (v *sceneValidator) require(path string, fields ...string) {
+	for _, field := range fields {
+		if !v.has(joinPath(path, field)) {
+			v.errorf(joinPath(path, field), "missing required field")
+		}
+	}
+}
+
+// material returns the index of the material referenced by the object at the given path.
+// Records an error if the material is unknown.
+func (v *sceneValidator) material(path, name string) int {
+	if !v.ok(joinPath(path, "material")) {
+		return -1 // Already reported.
+	}
+	idx, ok := v.materials[name]
+	if !ok {
+		v.errorf(joinPath(path, "material"), "unknown material %q", name)
+		return -1
+	}
+	return idx
+}
+
+// addMaterial registers a material not defined in the scene file, like the ones from mesh MTL files.
+// The key identifies it for addedMaterial, the scene objects can't reference it by name.
+// Returns its index.
+func (v *sceneValidator) addMaterial(key string, m material) int {
+	m.index = len(v.materials) + len(v.added)
+	v.addedKeys[key] = m.index
+	v.added = append(v.added, m)
+	return m.index
+}
+
+// addedMaterial returns the index of the material added with the given key, if any.
+func (v *sceneValidator) addedMaterial(key string) (int, bool) {
+	idx, ok := v.addedKeys[key]
+	return idx, ok
+}
+
+// materialAt returns the material of the given index, defined in the scene file or added.
+func (v *sceneValidator) materialAt(idx int) material {
+	if idx < len(v.defined) {
+		return v.defined[idx]
+	}
+	return v.added[idx-len(v.defined)]
+}
+
+// positive records an error if the given field is not strictly positive.
+func (v *sceneValidator) positive(path, field string, value float) {
+	if v.ok(joinPath(path, field)) && value <= 0 {
+		v.errorf(joinPath(path, field), "must be greater than 0, got %g", value)
+	}
+}
+
+// decodeFields unmarshals the JSON object at the given path into the target struct field by field,
+// so every invalid field gets reported instead of only the first one.
+// Returns false if the value is not an object.
+func (v *sceneValidator) decodeFields(path string, data json.RawMessage, target any) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		v.errorf(path, "expected an object")
+		return false
+	}
+
+	rv := reflect.ValueOf(target).Elem()
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+		raw, ok := fields[name]
+		if name == "" || !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, rv.Field(i).Addr().Interface()); err != nil {
+			v.invalid[joinPath(path, name)] = true
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				v.errorf(joinPath(path, name), "expected %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)
+				continue
+			}
+			v.errorf(joinPath(path, name), "%s", err)
+		}
+	}
+	return true
+}
+
+// jsonTypeName returns the JSON name of the given Go type for the error messages.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() { //nolint:exhaustive // Default case handles the rest.
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int32, reflect.Int64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// parseScene parses and validates the given scene file content.
+// fsys is used to load the files referenced by the scene.
+func parseScene(fsys fs.FS, buf []byte) (scene, error) {
+	lines, err := indexJSONLines(buf)
+	if err != nil {
+		return scene{}, err
+	}
+	v := &sceneValidator{fsys: fsys, lines: lines, invalid: map[string]bool{}, materials: map[string]int{}, addedKeys: map[string]int{}}
+
+	var s scene
+	var raw struct {
+		Camera       json.RawMessage   `json:"camera"`
+		Objects      []json.RawMessage `json:"objects"`
+		AmbientLight json.RawMessage   `json:"ambient_light"`
+		Lights       []json.RawMessage `json:"lights"`
+		Materials    []json.RawMessage `json:"materials"`
+	}
+	if !v.decodeFields("", buf, &raw) {
+		return scene{}, v.err()
+	}
+
+	// Camera.
+	v.require("", "camera")
+	if raw.Camera != nil && v.decodeFields("camera", raw.Camera, &s.Camera) {
+		v.require("camera", "origin", "lookAt")
+		if s.Camera.Origin == s.Camera.LookAt && v.ok("camera.origin") && v.ok("camera.lookAt") {
+			v.errorf("camera", "origin and lookAt must be different")
+		}
+	}
+
+	// Materials first, the objects reference them.
+	s.Materials = make([]material, len(raw.Materials))
+	for i, elem := range raw.Materials {
+		path := fmt.Sprintf("materials[%d]", i)
+		if !v.decodeFields(path, elem, &s.Materials[i]) {
+			continue
+		}
+		s.Materials[i].validate(v, path)
+	}
+	// The patterns reference other materials, possibly defined after them.
+	for i, elem := range s.Materials {
+		if elem.pattern != nil {
+			elem.pattern.materialIdx = v.material(fmt.Sprintf("materials[%d].pattern", i), elem.pattern.Material)
+		}
+	}
+	v.defined = s.Materials
+
+	// Lights.
+	if raw.AmbientLight != nil {
+		v.decodeFields("ambient_light", raw.AmbientLight, &s.AmbientLight)
+	}
+	s.Lights = make([]light, len(raw.Lights))
+	for i, elem := range raw.Lights {
+		path := fmt.Sprintf("lights[%d]", i)
+		if !v.decodeFields(path, elem, &s.Lights[i]) {
+			continue
+		}
+		s.Lights[i].validate(v, path)
+	}
+
+	// Objects.
+	s.Objects = make(objects, 0, len(raw.Objects))
+	for i, elem := range raw.Objects {
+		path := fmt.Sprintf("objects[%d]", i)
+		if obj := parseObject(v, path, elem); obj != nil {
+			s.Objects = append(s.Objects, obj)
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return scene{}, v.err()
+	}
+	s.Materials = append(s.Materials, v.added...)
+	s.textures = v.textures
+
+	return s, nil
+}
+
+// parseObject instantiates the object using the factory based on its type, then decodes and validates it.
+func parseObject(v *sceneValidator, path string, data json.RawMessage) sceneObject {
+	// Extract the type of the object first.
+	var tmp struct {
+		Type string `json:"type"`
+	}
+	if !v.decodeFields(path, data, &tmp) {
+		return nil
+	}
+	v.require(path, "type")
+	newObject, ok := factory[tmp.Type]
+	if !ok {
+		if v.ok(joinPath(path, "type")) {
+			v.errorf(joinPath(path, "type"), "unknown object type %q", tmp.Type)
+		}
+		return nil
+	}
+
+	// Then unmarshal into the final object.
+	obj := newObject()
+	v.decodeFields(path, data, obj)
+	obj.validate(v, path)
+
+	// Any object can have a transform.
+	var tmpTransform struct {
+		Transform json.RawMessage `json:"transform"`
+	}
+	v.decodeFields(path, data, &tmpTransform)
+	if tmpTransform.Transform == nil {
+		return obj
+	}
+	transformPath := joinPath(path, "transform")
+	var t transform
+	if !v.decodeFields(transformPath, tmpTransform.Transform, &t) {
+		return obj
+	}
+	t.validate(v, transformPath)
+	return transformedObject{sceneObject: obj, matrix: t.matrix()}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIndexJSONLines(t *testing.T) {
+	t.Parallel()
+
+	doc := `{
+  "camera": {
+    "origin": [0, 0, 5],
+    "lookAt": [0, 0, 0]
+  },
+  "objects": [
+    {"type": "sphere", "radius": 1},
+    {
+      "type": "plane",
+      "normal": [
+        0, 1, 0
+      ],
+
+      "center": [0, 0, 0]
+    }
+  ]
+}`
+	lines, err := indexJSONLines([]byte(doc))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s.", err)
+	}
+	for path, want := range map[string]int{
+		"":                     1,
+		"camera":               2,
+		"camera.origin":        3,
+		"camera.origin[2]":     3,
+		"camera.lookAt":        4,
+		"objects":              6,
+		"objects[0]":           7,
+		"objects[0].radius":    7,
+		"objects[1]":           8,
+		"objects[1].type":      9,
+		"objects[1].normal":    10,
+		"objects[1].normal[1]": 11,
+		"objects[1].center":    14,
+	} {
+		if got, ok := lines[path]; !ok || got != want {
+			t.Errorf("Unexpected line for %q: %d (found: %t), expected %d.", path, got, ok, want)
+		}
+	}
+	if _, ok := lines["objects[2]"]; ok {
+		t.Error("Unexpected path objects[2].")
+	}
+}
+
+func TestIndexJSONLinesErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"empty", "", "empty document (line 1)"},
+		{"only spaces", "\n\n  \n", "empty document (line 1)"},
+		{"unclosed object", "{", "unexpected end of JSON input (line 1)"},
+		{"eof in nested array", "{\n  \"camera\": {\n    \"origin\": [0, 0, 5],\n", "unexpected end of JSON input (line 3)"},
+		{"eof after nested object", "{\n  \"camera\": {\"origin\": [0, 0, 5]}\n", "unexpected end of JSON input (line 2)"},
+		{"eof in string", "{\n  \"camera\": {\n    \"orig", "unexpected end of JSON input (line 3)"},
+		{"trailing comma", "{\n  \"a\": 1,\n  \"b\": 2,\n}", "invalid character ',' looking for beginning of value (line 3)"},
+		{"missing comma", "{\n  \"a\": [1, 2\n  \"b\": 2\n}", "invalid character '\"' after array element (line 3)"},
+		{"missing colon", "{\n  \"a\" 1\n}", "invalid character '1' after object key (line 2)"},
+		{"trailing value", "{\"a\": 1}\n{\"b\": 2}", "unexpected data after the end of the document (line 2)"},
+		{"trailing delimiter", "{\"a\": 1}\n\n}", "unexpected data after the end of the document (line 3)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := indexJSONLines([]byte(tc.doc))
+			if err == nil {
+				t.Fatalf("Expected an error, got none.")
+			}
+			if got := err.Error(); got != tc.want {
+				t.Fatalf("Unexpected error:\n%s\nexpected:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSceneErrors(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"tri.obj": {Data: []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nusemtl missing\nf 1 2 3\n")},
+	}
+
+	for _, tc := range []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "syntax error",
+			doc:  "{\n  \"camera\": {\n    \"origin\": [0, 0, 5],\n",
+			want: []string{"unexpected end of JSON input (line 3)"},
+		},
+		{
+			name: "not an object",
+			doc:  "[1, 2]",
+			want: []string{"expected an object (line 1)"},
+		},
+		{
+			name: "missing camera",
+			doc:  `{"objects": []}`,
+			want: []string{"camera: missing required field (line 1)"},
+		},
+		{
+			name: "missing nested fields",
+			doc: `{
+  "camera": {"origin": [0, 0, 5]},
+  "materials": [
+    {"type": "red", "color": [1, 0, 0, 1]},
+    {"color": [1, 1, 1, 1]}
+  ],
+  "objects": [
+    {"type": "sphere", "radius": 1, "material": "red"},
+    {"type": "plane", "center": [0, 0, 0], "normal": [0, 1, 0], "is_checkerboard": true, "material": "red"}
+  ],
+  "lights": [{}]
+}`,
+			want: []string{
+				"camera.lookAt: missing required field (line 2)",
+				"materials[1].type: missing required field (line 5)",
+				"objects[0].center: missing required field (line 8)",
+				"objects[1].checker_size: missing required field (line 9)",
+				"lights[0].color: missing required field (line 11)",
+				"lights[0].origin: missing required field (line 11)",
+			},
+		},
+		{
+			name: "invalid values",
+			doc: `{
+  "camera": {"origin": [0, 0, 5], "lookAt": [0, 0, 5]},
+  "materials": [{"type": "m", "color": [1, 1, 1, 1]}, {"type": "m", "color": [1, 1, 1, 1]}],
+  "objects": [
+    {"type": "sphere", "center": [0, 0], "radius": -1, "material": "m"},
+    {"type": "cube", "material": "m"},
+    {"type": 1, "material": "m"},
+    {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "gold"},
+    {"type": "cylinder", "center1": [0, 0, 0], "center2": [0, 0, 0], "radius": "1", "material": "m"}
+  ]
+}`,
+			want: []string{
+				"camera: origin and lookAt must be different (line 2)",
+				`materials[1].type: duplicate material "m" (line 3)`,
+				"objects[0].center: expected an array of 3 numbers, got [0, 0] (line 5)",
+				"objects[0].radius: must be greater than 0, got -1 (line 5)",
+				`objects[1].type: unknown object type "cube" (line 6)`,
+				"objects[2].type: expected string, got number (line 7)",
+				`objects[3].material: unknown material "gold" (line 8)`,
+				`objects[4].radius: expected number, got string (line 9)`,
+				"objects[4]: center1 and center2 must be different (line 9)",
+			},
+		},
+		{
+			name: "invalid material models",
+			doc: `{
+  "camera": {"origin": [0, 0, 5], "lookAt": [0, 0, 0]},
+  "materials": [
+    {"type": "a", "color": [1, 1, 1, 1], "specular_power": -1},
+    {"type": "b", "color": [1, 1, 1, 1], "metallic": 0.5},
+    {"type": "c", "color": [1, 1, 1, 1], "model": "pbr", "diffuse": 1, "roughness": 2},
+    {"type": "d", "color": [1, 1, 1, 1], "model": "toon"}
+  ]
+}`,
+			want: []string{
+				"materials[0].specular_power: must not be negative, got -1 (line 4)",
+				"materials[1].metallic: only used by the pbr model (line 5)",
+				"materials[2].diffuse: not used by the pbr model (line 6)",
+				"materials[2].roughness: must be between 0 and 1, got 2 (line 6)",
+				`materials[3].model: unknown model "toon", expected phong or pbr (line 7)`,
+			},
+		},
+		{
+			name: "unknown usemtl",
+			doc: `{
+  "camera": {"origin": [0, 0, 5], "lookAt": [0, 0, 0]},
+  "objects": [
+    {"type": "mesh", "file": "tri.obj"}
+  ]
+}`,
+			want: []string{`objects[0].file: tri.obj:4: unknown material "missing", not in the scene nor in the mtllib files (line 4)`},
+		},
+		{
+			name: "invalid mesh",
+			doc: `{
+  "camera": {"origin": [0, 0, 5], "lookAt": [0, 0, 0]},
+  "objects": [
+    {"type": "mesh", "file": "missing.obj", "scale": 0},
+    {"type": "triangle", "v0": [0, 0, 0], "v1": [1, 1, 1], "v2": [2, 2, 2], "n0": [0, 0, 1], "material": "m"}
+  ]
+}`,
+			want: []string{
+				"objects[0].scale: must be greater than 0, got 0 (line 4)",
+				"objects[0].file: failed to read mesh: open missing.obj: file does not exist (line 4)",
+				"objects[1]: v0, v1 and v2 must not be aligned (line 5)",
+				"objects[1].n1: missing required field (line 5)",
+				"objects[1].n2: missing required field (line 5)",
+				`objects[1].material: unknown material "m" (line 5)`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseScene(fsys, []byte(tc.doc))
+			if err == nil {
+				t.Fatalf("Expected an error, got none.")
+			}
+			if got, want := err.Error(), strings.Join(tc.want, "\n"); got != want {
+				t.Fatalf("Unexpected error:\n%s\nexpected:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !headless
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// This file holds the scene hot-reload logic.
+// The current scene file is polled and reloaded when modified.
+// Errors are displayed in the HUD while the last good scene keeps rendering.
+
+// sceneWatchInterval is the number of ticks between two checks of the scene file.
+const sceneWatchInterval = 30
+
+// currentSceneModTime returns the modification time of the current scene file.
+// Embedded files don't have a modification time, so they never get reloaded.
+func (g *Game) currentSceneModTime() time.Time {
+	fi, err := fs.Stat(g.scenes.fsys, g.scenes.names[g.sceneIdx])
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// watchScene reloads the current scene when the file changed since the last load.
+func (g *Game) watchScene() {
+	if g.time%sceneWatchInterval != 0 {
+		return
+	}
+	modTime := g.currentSceneModTime()
+	if modTime.IsZero() || modTime.Equal(g.sceneModTime) {
+		return
+	}
+	g.loadScene(true)
+}
+
+// loadScene (re)loads the current scene file.
+// In GPU mode, a bigger shader gets compiled in the background if the current one can't hold the scene.
+// On error, the last good scene is kept and the error is stored to be displayed.
+// When reloading, the camera is kept unless it got changed in the file.
+func (g *Game) loadScene(reload bool) {
+	name := g.scenes.names[g.sceneIdx]
+	g.sceneModTime = g.currentSceneModTime()
+
+	s, err := g.scenes.load(g.sceneIdx)
+	if err != nil {
+		g.sceneErr = fmt.Errorf("load scene %s: %w", name, err)
+		return
+	}
+
+	fileCamera := s.Camera
+	if reload && fileCamera == g.sceneCamera {
+		s.Camera = g.scene.Camera
+	}
+
+	g.scene = s
+	g.sceneCamera = fileCamera
+	g.sceneErr = nil
+
+	if g.renderMode == RenderModeGPU {
+		g.requestShader(s.data)
+	}
+}
@@ -1,6 +1,9 @@
+//go:build !headless
+
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -18,19 +21,78 @@ var (
 	shaderKage embed.FS
 )
 
+// shaderStatus enum type.
+type shaderStatus int
+
+// shaderStatus enum values.
+const (
+	shaderStatusNone shaderStatus = iota
+	shaderStatusCompiling
+	shaderStatusReady
+	shaderStatusFailed
+)
+
 type shader struct {
+	status          shaderStatus
 	data            *ebiten.Shader
 	err             error
 	compileDuration time.Duration
+	capacity        shaderCapacity
+
+	preprocessDuration time.Duration // From the disk cache metadata when preprocessCached is set.
+	preprocessCached   bool          // The preprocessed source got loaded from the disk cache.
+}
+
+// fits returns true if the shader is compiled and can hold the given scene.
+func (s shader) fits(d sceneData) bool {
+	return s.status == shaderStatusReady && s.capacity.fits(d)
+}
+
+// shaderJob is a shader compilation running in the background.
+type shaderJob struct {
+	capacity shaderCapacity
+	cancel   context.CancelFunc
+	done     chan shader
+}
+
+// compileShaderAsync starts compiling the shader for the given capacity in a goroutine.
+func compileShaderAsync(cache *shaderCache, c shaderCapacity) *shaderJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &shaderJob{
+		capacity: c,
+		cancel:   cancel,
+		done:     make(chan shader, 1), // Buffered so the goroutine doesn't leak when the job gets dropped.
+	}
+	go func() { job.done <- compileShader(ctx, cache, c) }()
+	return job
 }
 
-func compileShader(s scene) shader {
+// poll returns the compiled shader if the job is done.
+func (j *shaderJob) poll() (shader, bool) {
+	select {
+	case s := <-j.done:
+		return s, true
+	default:
+		return shader{status: shaderStatusCompiling, capacity: j.capacity}, false
+	}
+}
+
+// compileShader compiles the shader for the given capacity.
+// The scene is passed as uniforms, so the same shader serves every scene fitting in it.
+// Ebiten's compiler can't be interrupted, so the context is checked before compiling
+// and a shader compiled after the cancellation is only kept in the cache.
+func compileShader(ctx context.Context, cache *shaderCache, c shaderCapacity) shader {
+	// Reject the scenes over the uniforms limit upfront, the compilation would fail or, worse, not render.
+	if err := c.validate(); err != nil {
+		return shader{status: shaderStatusFailed, err: err, capacity: c}
+	}
+
 	var shaderBufs [][]byte
 
 	// Read the embeded files.
 	shaderGoEmbed, err := shaderGo.ReadDir(".")
 	if err != nil {
-		panic(fmt.Errorf("read directory shaderGo %q: %w", err))
+		panic(fmt.Errorf("read directory shaderGo: %w", err))
 	}
 	for _, elem := range shaderGoEmbed {
 		buf, err := shaderGo.ReadFile(elem.Name())
@@ -41,7 +103,7 @@ func compileShader(s scene) shader {
 	}
 	shaderKageEmbed, err := shaderKage.ReadDir(".")
 	if err != nil {
-		panic(fmt.Errorf("read directory shaderKage %q: %w", err))
+		panic(fmt.Errorf("read directory shaderKage: %w", err))
 	}
 	for _, elem := range shaderKageEmbed {
 		buf, err := shaderKage.ReadFile(elem.Name())
@@ -51,21 +113,45 @@ func compileShader(s scene) shader {
 		shaderBufs = append(shaderBufs, buf)
 	}
 
+	// Preprocess the Go code into Kage shader code, unless already done in a previous run.
+	str, key, meta, fromDisk := cache.source(c, shaderBufs)
+	dumpCompiledShader(str)
+
+	// Reuse the shader if the same source got compiled already.
+	if s, ok := cache.get(meta.SourceHash); ok {
+		return s
+	}
+	if err := ctx.Err(); err != nil {
+		return shader{status: shaderStatusFailed, err: err, capacity: c}
+	}
+
+	// Compile the shader.
 	shaderData, err, duration := trackTime(func() (*ebiten.Shader, error) {
-		// Preprocess the Go code into Kage shader code.
-		str := preprocess(s, shaderBufs...)
-		dumpCompiledShader(str)
-		// Compile the shader.
 		return ebiten.NewShader([]byte(str))
 	})
 	if err != nil {
 		log.Printf("Error compiling shader: %s.", err)
+		return shader{status: shaderStatusFailed, err: err, compileDuration: duration, capacity: c}
 	}
-	return shader{
-		data:            shaderData,
-		err:             err,
-		compileDuration: duration,
+	s := shader{
+		status:             shaderStatusReady,
+		data:               shaderData,
+		compileDuration:    duration,
+		preprocessDuration: meta.PreprocessDuration,
+		preprocessCached:   fromDisk,
+		capacity:           c,
+	}
+	cache.put(meta.SourceHash, s)
+	if !fromDisk {
+		meta.CompileDuration = duration
+		cache.storeSource(key, str, meta)
+	}
+
+	// Stale, keep it only for the cache.
+	if err := ctx.Err(); err != nil {
+		return shader{status: shaderStatusFailed, err: err, capacity: c}
 	}
+	return s
 }
 
 func dumpCompiledShader(str string) {
@@ -0,0 +1,131 @@
+//go:build !headless
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// This file holds the shader cache.
+// Compiled shaders are kept in memory for the session, keyed by the hash of their Kage source.
+// On native builds, the preprocessed source is also kept on disk, keyed by the hash of the input files and capacity,
+// the preprocessor version and the build.
+
+// shaderCache caches the shaders and their preprocessed source.
+// Safe for concurrent use, the shaders get compiled in the background.
+type shaderCache struct {
+	mu      sync.Mutex
+	shaders map[string]shader // Keyed by source hash.
+	disk    bool              // Whether to use the on-disk cache.
+}
+
+// newShaderCache creates an empty cache. disk enables the on-disk cache of the preprocessed source.
+func newShaderCache(disk bool) *shaderCache {
+	return &shaderCache{
+		shaders: map[string]shader{},
+		disk:    disk,
+	}
+}
+
+// get returns the compiled shader for the given source hash.
+func (c *shaderCache) get(sourceHash string) (shader, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.shaders[sourceHash]
+	return s, ok
+}
+
+// put stores the compiled shader for the given source hash.
+func (c *shaderCache) put(sourceHash string, s shader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shaders[sourceHash] = s
+}
+
+// shaderCacheMeta is the metadata stored alongside the preprocessed source on disk.
+type shaderCacheMeta struct {
+	SourceHash         string        `json:"source_hash"`
+	Capacity           [3]int        `json:"capacity"` // Lights, materials, textures.
+	PreprocessDuration time.Duration `json:"preprocess_duration"`
+	CompileDuration    time.Duration `json:"compile_duration"`
+	CreatedAt          time.Time     `json:"created_at"`
+}
+
+// source returns the preprocessed Kage source for the given capacity and input files, from the disk cache if possible.
+// The returned key identifies the inputs, to store the source back with storeSource.
+func (c *shaderCache) source(capacity shaderCapacity, files [][]byte) (src, key string, meta shaderCacheMeta, fromDisk bool) {
+	key = hashInputs(capacity, files)
+	if c.disk {
+		if src, meta, ok := readDiskShaderCache(key); ok && hashSource(src) == meta.SourceHash {
+			return src, key, meta, true
+		}
+	}
+
+	src, _, duration := trackTime(func() (string, error) {
+		return preprocess(capacity, files...), nil
+	})
+	return src, key, shaderCacheMeta{
+		SourceHash:         hashSource(src),
+		Capacity:           [3]int{capacity.lights, capacity.materials, capacity.textures},
+		PreprocessDuration: duration,
+		CreatedAt:          time.Now(),
+	}, false
+}
+
+// storeSource writes the preprocessed source and its metadata to the disk cache, if enabled.
+// Best effort, the cache is only an optimization.
+func (c *shaderCache) storeSource(key, src string, meta shaderCacheMeta) {
+	if !c.disk {
+		return
+	}
+	if err := writeDiskShaderCache(key, src, meta); err != nil {
+		log.Printf("Error writing shader cache: %s.", err)
+	}
+}
+
+// hashInputs returns the hash of the shader input files and capacity.
+// The preprocessor is not an input file, its version and the build are hashed instead,
+// so an upgrade doesn't reuse the sources preprocessed by an older binary.
+func hashInputs(capacity shaderCapacity, files [][]byte) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d,%s\n", preprocessVersion, buildVersion())
+	_, _ = fmt.Fprintf(h, "%d,%d,%d\n", capacity.lights, capacity.materials, capacity.textures) // Hash writes never fail.
+	for _, elem := range files {
+		_, _ = fmt.Fprintf(h, "%d\n", len(elem)) // Length prefix so the file boundaries are part of the hash.
+		_, _ = h.Write(elem)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashSource returns the hash of the preprocessed Kage source.
+func hashSource(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// buildVersion returns the version control revision of the binary, or its module version when not built from a checkout.
+// Empty when the build info is not available.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	version := info.Main.Version
+	for _, elem := range info.Settings {
+		switch elem.Key {
+		case "vcs.revision":
+			version = elem.Value
+		case "vcs.modified":
+			if elem.Value == "true" {
+				version += "-dirty"
+			}
+		}
+	}
+	return version
+}
@@ -0,0 +1,110 @@
+//go:build !js && !headless
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxDiskShaderCacheEntries is the number of preprocessed sources kept on disk, the least recently used get removed.
+const maxDiskShaderCacheEntries = 16
+
+// shaderCacheDir returns the directory of the on-disk shader cache.
+func shaderCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "rtv1"), nil
+}
+
+// readDiskShaderCache reads the preprocessed source and its metadata for the given key.
+// Any error is treated as a cache miss.
+func readDiskShaderCache(key string) (string, shaderCacheMeta, bool) {
+	dir, err := shaderCacheDir()
+	if err != nil {
+		return "", shaderCacheMeta{}, false
+	}
+	srcPath := filepath.Join(dir, key+".kage")
+	src, err := os.ReadFile(srcPath)
+	if err != nil {
+		return "", shaderCacheMeta{}, false
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return "", shaderCacheMeta{}, false
+	}
+	var meta shaderCacheMeta
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return "", shaderCacheMeta{}, false
+	}
+	// Mark the entry as recently used for the eviction. Best effort.
+	now := time.Now()
+	_ = os.Chtimes(srcPath, now, now)
+	return string(src), meta, true
+}
+
+// writeDiskShaderCache writes the preprocessed source and its metadata for the given key.
+// A partially written entry gets rejected on read as the source doesn't match the hash in the metadata.
+// The least recently used entries are then removed, see pruneDiskShaderCache.
+func writeDiskShaderCache(key, src string, meta shaderCacheMeta) error {
+	dir, err := shaderCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create cache dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".kage"), []byte(src), 0o600); err != nil {
+		return fmt.Errorf("write source: %w", err)
+	}
+	buf, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".json"), buf, 0o600); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+	return pruneDiskShaderCache(dir, maxDiskShaderCacheEntries)
+}
+
+// pruneDiskShaderCache removes the least recently used entries of the cache directory, keeping the given number of them.
+// The modification time of the source tracks the last use of an entry.
+func pruneDiskShaderCache(dir string, keep int) error {
+	sources, err := filepath.Glob(filepath.Join(dir, "*.kage"))
+	if err != nil {
+		return fmt.Errorf("list cache entries: %w", err)
+	}
+	if len(sources) <= keep {
+		return nil
+	}
+	type entry struct {
+		base    string // Path without the extension.
+		modTime time.Time
+	}
+	entries := make([]entry, 0, len(sources))
+	for _, elem := range sources {
+		fi, err := os.Stat(elem)
+		if err != nil {
+			continue // Removed meanwhile, by another instance.
+		}
+		entries = append(entries, entry{base: strings.TrimSuffix(elem, ".kage"), modTime: fi.ModTime()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return b.modTime.Compare(a.modTime) })
+	for _, elem := range entries[min(keep, len(entries)):] {
+		for _, ext := range []string{".kage", ".json"} {
+			if err := os.Remove(elem.base + ext); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("remove cache entry: %w", err)
+			}
+		}
+	}
+	return nil
+}
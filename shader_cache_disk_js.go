@@ -0,0 +1,13 @@
+//go:build js && !headless
+
+package main
+
+// There is no disk in the browser, the on-disk shader cache is a no-op.
+
+func readDiskShaderCache(string) (string, shaderCacheMeta, bool) {
+	return "", shaderCacheMeta{}, false
+}
+
+func writeDiskShaderCache(string, string, shaderCacheMeta) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build !js && !headless
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestPruneDiskShaderCache checks the least recently used entries get removed, with their metadata.
+func TestPruneDiskShaderCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Now()
+	// Entry "e<i>" is used i hours ago.
+	for i, name := range []string{"e0", "e1", "e2", "e3", "e4"} {
+		for _, ext := range []string{".kage", ".json"} {
+			p := filepath.Join(dir, name+ext)
+			if err := os.WriteFile(p, []byte(name), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			used := now.Add(-time.Duration(i) * time.Hour)
+			if err := os.Chtimes(p, used, used); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Metadata without source, left alone.
+	if err := os.WriteFile(filepath.Join(dir, "orphan.json"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneDiskShaderCache(dir, 2); err != nil {
+		t.Fatalf("Prune: %s.", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, elem := range entries {
+		got = append(got, elem.Name())
+	}
+	if expect := []string{"e0.json", "e0.kage", "e1.json", "e1.kage", "orphan.json"}; !slices.Equal(got, expect) {
+		t.Errorf("Unexpected entries after prune.\nGot:    %q\nExpect: %q", got, expect)
+	}
+
+	// Under the limit, nothing to do.
+	if err := pruneDiskShaderCache(dir, 2); err != nil {
+		t.Fatalf("Prune again: %s.", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(got) {
+		t.Errorf("Unexpected prune under the limit, %d entries left, expected %d.", len(entries), len(got))
+	}
+}
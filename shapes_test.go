@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// approxVec3 returns whether the vectors are equal within the given tolerance.
+func approxVec3(a, b vec3, tolerance float) bool {
+	return length3(sub3(a, b)) <= tolerance
+}
+
+// TestCylinderCaps checks the rays along the axis only hit the capped cylinders, on the cap facing them,
+// and the infinite ones are hit far from their centers.
+func TestCylinderCaps(t *testing.T) {
+	t.Parallel()
+
+	down := newVec3(0, -1, 0)
+	open := newCylinder(newVec3(0, 0, 0), newVec3(0, 2, 0), 1, false, false, 0)
+	capped := newCylinder(newVec3(0, 0, 0), newVec3(0, 2, 0), 1, true, false, 0)
+	infinite := newCylinder(newVec3(0, 0, 0), newVec3(0, 2, 0), 1, false, true, 0)
+
+	if dist := hitCylinder(newVec3(0, 5, 0), down, open, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g through the open cylinder.", dist)
+	}
+	if dist := hitCylinder(newVec3(0.5, 5, 0), down, capped, 0.001, -1); math.Abs(dist-3) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the top cap at 3.", dist)
+	}
+	if n := normalCylinder(capped, newVec3(0.5, 2, 0)); !approxVec3(n, newVec3(0, 1, 0), 1e-9) {
+		t.Errorf("Unexpected top cap normal %v.", n)
+	}
+	if n := normalCylinder(capped, newVec3(0.5, 0, 0)); !approxVec3(n, newVec3(0, -1, 0), 1e-9) {
+		t.Errorf("Unexpected bottom cap normal %v.", n)
+	}
+	if dist := hitCylinder(newVec3(5, 100, 0), newVec3(-1, 0, 0), infinite, 0.001, -1); math.Abs(dist-4) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the infinite side at 4.", dist)
+	}
+	if dist := hitCylinder(newVec3(5, 100, 0), newVec3(-1, 0, 0), capped, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g past the end of the capped cylinder.", dist)
+	}
+	if _, _, ok := thingBounds(infinite); ok {
+		t.Error("Unexpected bounds for the infinite cylinder.")
+	}
+}
+
+// TestCone checks the cone side normal follows the slope, the base cap and the other side of the infinite cones.
+func TestCone(t *testing.T) {
+	t.Parallel()
+
+	// Base of radius 1 at the origin, apex 2 units up.
+	base, apex := newVec3(0, 0, 0), newVec3(0, 2, 0)
+	cone := newCone(base, apex, 1, false, false, 0)
+	capped := newCone(base, apex, 1, true, false, 0)
+	infinite := newCone(base, apex, 1, false, true, 0)
+
+	// Half way up, the radius is 0.5, the side goes along (1, -2, 0).
+	if n := normalCone(cone, newVec3(0.5, 1, 0)); !approxVec3(n, normalize3(newVec3(2, 1, 0)), 1e-9) {
+		t.Errorf("Unexpected side normal %v, expected %v.", n, normalize3(newVec3(2, 1, 0)))
+	}
+
+	up := newVec3(0, 1, 0)
+	if dist := hitCone(newVec3(0.2, -5, 0), up, cone, 0.001, -1); math.Abs(dist-6.6) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the inside of the side at 6.6 through the open base.", dist)
+	}
+	if dist := hitCone(newVec3(0.2, -5, 0), up, capped, 0.001, -1); math.Abs(dist-5) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the base cap at 5.", dist)
+	}
+	if n := normalCone(capped, newVec3(0.2, 0, 0)); !approxVec3(n, newVec3(0, -1, 0), 1e-9) {
+		t.Errorf("Unexpected base cap normal %v.", n)
+	}
+
+	// 2 units above the apex, the mirrored side has a radius of 1 again.
+	left := newVec3(-1, 0, 0)
+	if dist := hitCone(newVec3(5, 4, 0), left, infinite, 0.001, -1); math.Abs(dist-4) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the other side of the infinite cone at 4.", dist)
+	}
+	if dist := hitCone(newVec3(5, 4, 0), left, cone, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g above the apex of the finite cone.", dist)
+	}
+	if n := normalCone(infinite, newVec3(1, 4, 0)); !approxVec3(n, normalize3(newVec3(2, -1, 0)), 1e-9) {
+		t.Errorf("Unexpected normal %v on the other side, expected %v.", n, normalize3(newVec3(2, -1, 0)))
+	}
+}
+
+// TestBox checks the rotated box is hit on its faces from outside and inside, with the face normal and coordinates.
+func TestBox(t *testing.T) {
+	t.Parallel()
+
+	// 2x4x6 box at (1, 0, 0), turned 90° around z: its first axis goes along y.
+	box := newBox(newVec3(1, 0, 0), newVec3(0, 1, 0), newVec3(-1, 0, 0), newVec3(1, 2, 3), 0)
+
+	if dist := hitBox(newVec3(10, 0, 0), newVec3(-1, 0, 0), box, 0.001, -1); math.Abs(dist-7) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected the face at x = 3.", dist)
+	}
+	if n := normalBox(box, newVec3(3, 0.5, 1)); !approxVec3(n, newVec3(1, 0, 0), 1e-9) {
+		t.Errorf("Unexpected normal %v on the x = 3 face.", n)
+	}
+	if dist := hitBox(newVec3(1, 0, 0), newVec3(0, 1, 0), box, 0.001, -1); math.Abs(dist-1) > 1e-9 {
+		t.Errorf("Unexpected hit at %g from inside, expected the face at y = 1.", dist)
+	}
+	if n := normalBox(box, newVec3(1, 1, 0)); !approxVec3(n, newVec3(0, 1, 0), 1e-9) {
+		t.Errorf("Unexpected normal %v on the y = 1 face.", n)
+	}
+	if dist := hitBox(newVec3(10, 1.5, 0), newVec3(-1, 0, 0), box, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g above the box.", dist)
+	}
+	if uv := uvBox(box, newVec3(1.5, 1, 1.5)); !approxVec3(newVec3(uv.x, uv.y, 0), newVec3(0.375, 0.75, 0), 1e-9) {
+		t.Errorf("Unexpected coordinates %v on the y = 1 face.", uv)
+	}
+}
+
+// TestQuad checks the parallelogram is hit within its edges and uvQuad is the inverse of quadPoint.
+func TestQuad(t *testing.T) {
+	t.Parallel()
+
+	center, edge1, edge2 := newVec3(0, 1, 0), newVec3(2, 0, 0), newVec3(1, 0, 1)
+	quad := newQuad(center, edge1, edge2, 0)
+	down := newVec3(0, -1, 0)
+
+	for _, uv := range []vec2{newVec2(0, 0), newVec2(0.25, 0.75), newVec2(1, 1)} {
+		p := quadPoint(center, edge1, edge2, uv)
+		if got := uvQuad(quad, p); math.Abs(got.x-uv.x) > 1e-9 || math.Abs(got.y-uv.y) > 1e-9 {
+			t.Errorf("Unexpected coordinates %v of %v, expected %v.", got, p, uv)
+		}
+		if dist := hitQuad(add3(p, newVec3(0, 3, 0)), down, quad, 0.001, -1); math.Abs(dist-3) > 1e-9 {
+			t.Errorf("Unexpected hit at %g at %v, expected 3.", dist, uv)
+		}
+	}
+	// Inside the bounding rectangle but outside the parallelogram.
+	if dist := hitQuad(newVec3(-2.5, 5, 0.9), down, quad, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g outside of the quad.", dist)
+	}
+	if n := normalQuad(quad, center); !approxVec3(n, newVec3(0, -1, 0), 1e-9) {
+		t.Errorf("Unexpected normal %v, expected edge1 x edge2.", n)
+	}
+}
+
+// TestDisc checks the disc is only hit within its radius.
+func TestDisc(t *testing.T) {
+	t.Parallel()
+
+	disc := newDisc(newVec3(0, 0, 0), newVec3(0, 0, 1), 2, 0)
+	back := newVec3(0, 0, -1)
+	if dist := hitDisc(newVec3(1.9, 0, 5), back, disc, 0.001, -1); math.Abs(dist-5) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected 5.", dist)
+	}
+	if dist := hitDisc(newVec3(1.5, 1.5, 5), back, disc, 0.001, -1); dist != 0 {
+		t.Errorf("Unexpected hit at %g outside of the radius.", dist)
+	}
+	if uv := uvDisc(disc, newVec3(0, 0, 0)); uv != newVec2(0.5, 0.5) {
+		t.Errorf("Unexpected coordinates %v of the center.", uv)
+	}
+}
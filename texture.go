@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg" // Register the JPEG decoder.
+	_ "image/png"  // Register the PNG decoder.
+	"io/fs"
+	"slices"
+)
+
+// This file holds the textures of the materials, images loaded from PNG or JPEG files or procedural ones.
+// The sampling is in k_rtv1_texture.go.
+//
+// Example:
+//
+//	{"type": "earth", "color": [1, 1, 1, 1], "diffuse": 0.9, "texture": {"file": "earth.png", "wrap": "clamp"}}
+//	{"type": "marble", "color": [1, 1, 1, 1], "diffuse": 0.9, "texture": {"type": "marble", "frequency": 2, "turbulence": 4}}
+
+// The uses of the textures, validated differently.
+const (
+	colorTexture     = iota // Multiplying the color of the material.
+	normalMapTexture        // Image of the normals in the surface frame.
+	bumpTexture             // Procedural height.
+)
+
+// defaultBumpStrength is the height of the bumps when not set.
+const defaultBumpStrength = 0.1
+
+// defaultNoiseOctaves is the number of octaves of the procedural textures when not set.
+const defaultNoiseOctaves = 4
+
+var textureTypes = map[string]int{
+	"image":      ImageTexture,
+	"noise":      NoiseTexture,
+	"turbulence": TurbulenceTexture,
+	"marble":     MarbleTexture,
+	"wood":       WoodTexture,
+	"stripes":    StripesTexture,
+	"rings":      RingsTexture,
+	"gradient":   GradientTexture,
+}
+
+var textureSpaces = map[string]int{
+	"object": TextureSpaceObject,
+	"world":  TextureSpaceWorld,
+}
+
+var noiseKinds = map[string]int{
+	"perlin": NoisePerlin,
+	"value":  NoiseValue,
+}
+
+var textureWrapModes = map[string]int{
+	"repeat": TextureWrapRepeat,
+	"mirror": TextureWrapMirror,
+	"clamp":  TextureWrapClamp,
+}
+
+var textureFilters = map[string]int{
+	"bilinear": TextureFilterBilinear,
+	"nearest":  TextureFilterNearest,
+}
+
+type texture struct {
+	Type string `json:"type"` // image (default), noise, turbulence, marble, wood, stripes, rings or gradient.
+
+	// Image.
+	File   string `json:"file"`
+	Scale  vec2   `json:"scale"`  // Multiplies the UV coordinates, e.g. the number of repetitions around a sphere.
+	Offset vec2   `json:"offset"` // Added to the UV coordinates after the scale.
+	Wrap   string `json:"wrap"`   // Outside of the 0..1 range: repeat (default), mirror or clamp.
+	Filter string `json:"filter"` // bilinear (default) or nearest.
+
+	// Procedural.
+	Color1     vec4   `json:"color1"`     // Where the pattern is 0, black by default.
+	Color2     vec4   `json:"color2"`     // Where the pattern is 1, white by default.
+	Axis       vec3   `json:"axis"`       // Direction of the stripes and gradient, axis of the rings, up by default.
+	Frequency  float  `json:"frequency"`  // Periods per scene unit.
+	Octaves    int    `json:"octaves"`    // Of the noise.
+	Turbulence float  `json:"turbulence"` // Amount of noise added to the pattern.
+	Space      string `json:"space"`      // object (default) or world.
+	Noise      string `json:"noise"`      // perlin (default) or value.
+
+	// Normal map and bump.
+	Strength float `json:"strength"` // Tilt of the normal map, or height of the bump in scene units.
+
+	image image.Image
+}
+
+func (t *texture) validate(v *sceneValidator, path string, usage int) {
+	if !v.has(joinPath(path, "type")) {
+		t.Type = "image"
+		if usage == bumpTexture {
+			t.Type = "noise"
+		}
+	}
+	if _, ok := textureTypes[t.Type]; !ok {
+		if v.ok(joinPath(path, "type")) {
+			v.errorf(joinPath(path, "type"), "unknown texture type %q", t.Type)
+		}
+		return
+	}
+	if usage == normalMapTexture && t.Type != "image" {
+		v.errorf(joinPath(path, "type"), "must be image, got %q", t.Type)
+		return
+	}
+	if usage == bumpTexture && t.Type == "image" {
+		v.errorf(joinPath(path, "type"), "must be a procedural texture")
+		return
+	}
+
+	if !v.has(joinPath(path, "strength")) {
+		t.Strength = 1
+		if usage == bumpTexture {
+			t.Strength = defaultBumpStrength
+		}
+	}
+	if t.Type == "image" {
+		t.validateImage(v, path)
+		return
+	}
+
+	if !v.has(joinPath(path, "color1")) {
+		t.Color1 = newVec4(0, 0, 0, 1)
+	}
+	if !v.has(joinPath(path, "color2")) {
+		t.Color2 = newVec4(1, 1, 1, 1)
+	}
+	if !v.has(joinPath(path, "axis")) {
+		t.Axis = newVec3(0, 1, 0)
+	} else if v.ok(joinPath(path, "axis")) && length3(t.Axis) == 0 {
+		v.errorf(joinPath(path, "axis"), "must not be a zero vector")
+	}
+	if !v.has(joinPath(path, "frequency")) {
+		t.Frequency = 1
+	}
+	v.positive(path, "frequency", t.Frequency)
+	if !v.has(joinPath(path, "octaves")) {
+		t.Octaves = defaultNoiseOctaves
+	} else if v.ok(joinPath(path, "octaves")) && (t.Octaves < 1 || t.Octaves > maxNoiseOctaves) {
+		v.errorf(joinPath(path, "octaves"), "must be between 1 and %d, got %d", maxNoiseOctaves, t.Octaves)
+	}
+	if !v.has(joinPath(path, "space")) {
+		t.Space = "object"
+	}
+	if _, ok := textureSpaces[t.Space]; !ok && v.ok(joinPath(path, "space")) {
+		v.errorf(joinPath(path, "space"), "unknown space %q, expected object or world", t.Space)
+	}
+	if !v.has(joinPath(path, "noise")) {
+		t.Noise = "perlin"
+	}
+	if _, ok := noiseKinds[t.Noise]; !ok && v.ok(joinPath(path, "noise")) {
+		v.errorf(joinPath(path, "noise"), "unknown noise %q, expected perlin or value", t.Noise)
+	}
+
+	// The bump height is the value of the pattern, from 0 to the strength.
+	if usage == bumpTexture {
+		t.Color1 = newVec4(0, 0, 0, 1)
+		t.Color2 = newVec4(t.Strength, t.Strength, t.Strength, 1)
+	}
+}
+
+func (t *texture) validateImage(v *sceneValidator, path string) {
+	v.require(path, "file")
+	if !v.has(joinPath(path, "scale")) {
+		t.Scale = newVec2(1, 1)
+	}
+	if v.ok(joinPath(path, "scale")) && (t.Scale.x == 0 || t.Scale.y == 0) {
+		v.errorf(joinPath(path, "scale"), "must not have a zero component")
+	}
+	if !v.has(joinPath(path, "wrap")) {
+		t.Wrap = "repeat"
+	}
+	if _, ok := textureWrapModes[t.Wrap]; !ok && v.ok(joinPath(path, "wrap")) {
+		v.errorf(joinPath(path, "wrap"), "unknown wrap mode %q, expected repeat, mirror or clamp", t.Wrap)
+	}
+	if !v.has(joinPath(path, "filter")) {
+		t.Filter = "bilinear"
+	}
+	if _, ok := textureFilters[t.Filter]; !ok && v.ok(joinPath(path, "filter")) {
+		v.errorf(joinPath(path, "filter"), "unknown filter %q, expected bilinear or nearest", t.Filter)
+	}
+	if !v.ok(joinPath(path, "file")) {
+		return
+	}
+
+	img, err := loadTextureImage(v.fsys, t.File)
+	if err != nil {
+		v.errorf(joinPath(path, "file"), "%s", err)
+		return
+	}
+	t.image = img
+}
+
+// decodeTexture decodes and validates the texture at the given path, then adds it to the scene textures.
+// Returns nil if it is not an object.
+func decodeTexture(v *sceneValidator, path string, data json.RawMessage, usage int) (*texture, int) {
+	var t texture
+	if !v.decodeFields(path, data, &t) {
+		return nil, 0
+	}
+	t.validate(v, path, usage)
+	v.textures = append(v.textures, t)
+	return &t, len(v.textures) - 1
+}
+
+// loadTextureImage reads the given PNG or JPEG file.
+func loadTextureImage(fsys fs.FS, name string) (image.Image, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open texture: %w", err)
+	}
+	defer func() { _ = f.Close() }() // Best effort.
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode texture: %w", err)
+	}
+	if b := img.Bounds(); b.Dx() > sourceMaxWidth || b.Dy() > sourceMaxWidth {
+		return nil, fmt.Errorf("texture too large: %dx%d, up to %dx%d", b.Dx(), b.Dy(), sourceMaxWidth, sourceMaxWidth)
+	}
+	return img, nil
+}
+
+// newTextures packs the images of the given textures in an atlas and encodes their descriptors, in the same order.
+// The images are laid out in rows, the tallest first. A file used by several textures is packed once.
+func newTextures(textures []texture) TexturesT {
+	out := TexturesT{items: make([]mat4, len(textures)), atlas: image.NewRGBA(image.Rectangle{})}
+
+	var order []int
+	for i, elem := range textures {
+		if elem.image != nil {
+			order = append(order, i)
+			continue
+		}
+		out.items[i] = newProceduralTexture(textureTypes[elem.Type], elem.Color1, elem.Color2, elem.Axis, elem.Frequency,
+			elem.Octaves, textureSpaces[elem.Space], noiseKinds[elem.Noise], elem.Turbulence)
+	}
+	if len(order) == 0 {
+		return out
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int {
+		return textures[b].image.Bounds().Dy() - textures[a].image.Bounds().Dy()
+	})
+
+	positions := map[string]image.Point{}
+	var x, y, rowHeight, width int
+	for _, i := range order {
+		if _, ok := positions[textures[i].File]; ok {
+			continue
+		}
+		size := textures[i].image.Bounds().Size()
+		if x+size.X > sourceMaxWidth {
+			x, y, rowHeight = 0, y+rowHeight, 0
+		}
+		positions[textures[i].File] = image.Pt(x, y)
+		x += size.X
+		rowHeight = max(rowHeight, size.Y)
+		width = max(width, x)
+	}
+
+	out.atlas = image.NewRGBA(image.Rect(0, 0, width, y+rowHeight))
+	for _, i := range order {
+		elem := textures[i]
+		pos := positions[elem.File]
+		b := elem.image.Bounds()
+		draw.Draw(out.atlas, image.Rectangle{Min: pos, Max: pos.Add(b.Size())}, elem.image, b.Min, draw.Src)
+		out.items[i] = newImageTexture(pos.X, pos.Y, b.Dx(), b.Dy(), elem.Scale, elem.Offset, textureWrapModes[elem.Wrap], textureFilters[elem.Filter], elem.Strength)
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestWrapTexel(t *testing.T) {
+	t.Parallel()
+
+	const size = 4
+	for _, tc := range []struct {
+		wrap    int
+		i, want int
+	}{
+		{TextureWrapRepeat, 0, 0},
+		{TextureWrapRepeat, 3, 3},
+		{TextureWrapRepeat, 4, 0},
+		{TextureWrapRepeat, -1, 3},
+		{TextureWrapRepeat, -4, 0},
+		{TextureWrapRepeat, -9, 3},
+		{TextureWrapMirror, 3, 3},
+		{TextureWrapMirror, 4, 3},
+		{TextureWrapMirror, 7, 0},
+		{TextureWrapMirror, 8, 0},
+		{TextureWrapMirror, -1, 0},
+		{TextureWrapMirror, -4, 3},
+		{TextureWrapMirror, -5, 3},
+		{TextureWrapClamp, -3, 0},
+		{TextureWrapClamp, 2, 2},
+		{TextureWrapClamp, 9, 3},
+	} {
+		if got := wrapTexel(tc.i, size, tc.wrap); got != tc.want {
+			t.Errorf("Unexpected texel %d for %d with wrap mode %d, expected %d.", got, tc.i, tc.wrap, tc.want)
+		}
+	}
+}
+
+// TestSampleTexture checks the sampling of a 2x2 image packed in the atlas next to another one.
+func TestSampleTexture(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	img.Set(1, 0, color.RGBA{G: 255, A: 255})
+	img.Set(0, 1, color.RGBA{B: 255, A: 255})
+	img.Set(1, 1, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	other := image.NewRGBA(image.Rect(0, 0, 3, 3))
+
+	nearest := texture{File: "a.png", image: img, Scale: newVec2(1, 1), Wrap: "repeat", Filter: "nearest"}
+	bilinear := texture{File: "a.png", image: img, Scale: newVec2(1, 1), Wrap: "clamp", Filter: "bilinear"}
+	textures := newTextures([]texture{nearest, {File: "b.png", image: other, Scale: newVec2(1, 1), Wrap: "repeat", Filter: "nearest"}, bilinear})
+	// The tallest image first, the shared file once.
+	if b := textures.atlas.Bounds(); b != image.Rect(0, 0, 5, 3) {
+		t.Fatalf("Unexpected atlas %v, expected 5x3.", b)
+	}
+
+	red, green, blue, white := newVec4(1, 0, 0, 1), newVec4(0, 1, 0, 1), newVec4(0, 0, 1, 1), newVec4(1, 1, 1, 1)
+	for _, tc := range []struct {
+		name string
+		idx  int
+		uv   vec2
+		want vec4
+	}{
+		{"nearest top left", 0, newVec2(0.25, 0.75), red},
+		{"nearest top right", 0, newVec2(0.75, 0.75), green},
+		{"nearest bottom left", 0, newVec2(0.25, 0.25), blue},
+		{"nearest repeated", 0, newVec2(1.75, -0.75), white},
+		{"bilinear texel center", 2, newVec2(0.75, 0.25), white},
+		{"bilinear between the texels", 2, newVec2(0.5, 0.5), newVec4(0.5, 0.5, 0.5, 1)},
+		{"bilinear clamped", 2, newVec2(-3, 0.75), red},
+	} {
+		if got := sampleTexture(textures, tc.idx, tc.uv); !approxVec3(got.xyz, tc.want.xyz, 1e-9) || got.w != tc.want.w {
+			t.Errorf("%s: unexpected color %v, expected %v.", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestNoise checks the noise range, the Perlin zeros on the lattice, and the continuity across the lattice cells.
+func TestNoise(t *testing.T) {
+	t.Parallel()
+
+	for _, kind := range []int{NoisePerlin, NoiseValue} {
+		var lo, hi float
+		for i := range 10000 {
+			p := newVec3(float(i%37)*0.37-5, float(i%101)*0.13-7, float(i)*0.011)
+			n := noise3(p, kind)
+			lo, hi = min(lo, n), max(hi, n)
+			if n < -1 || n > 1 {
+				t.Fatalf("Unexpected noise %g at %v out of -1..1.", n, p)
+			}
+			if d := math.Abs(noise3(add3(p, newVec3(1e-6, 1e-6, 1e-6)), kind) - n); d > 1e-4 {
+				t.Fatalf("Unexpected jump %g of the noise at %v.", d, p)
+			}
+			if turb := turbulence(p, 4, kind); turb < 0 || turb > 1 {
+				t.Fatalf("Unexpected turbulence %g at %v out of 0..1.", turb, p)
+			}
+		}
+		// Not flat.
+		if hi-lo < 0.5 {
+			t.Errorf("Unexpected noise %d range %g..%g.", kind, lo, hi)
+		}
+	}
+
+	for _, p := range []vec3{newVec3(0, 0, 0), newVec3(3, -2, 7), newVec3(-11, 5, -1)} {
+		if n := noise3(p, NoisePerlin); n != 0 {
+			t.Errorf("Unexpected Perlin noise %g on the lattice point %v.", n, p)
+		}
+	}
+}
+
+// TestProceduralTexture checks the stripes and gradient patterns along their axis, in periods of the frequency.
+func TestProceduralTexture(t *testing.T) {
+	t.Parallel()
+
+	black, white := newVec4(0, 0, 0, 1), newVec4(1, 1, 1, 1)
+	stripes := newProceduralTexture(StripesTexture, black, white, newVec3(2, 0, 0), 0.5, 1, TextureSpaceObject, NoisePerlin, 0)
+	for _, tc := range []struct {
+		x    float
+		want vec4
+	}{{0.5, black}, {1.5, white}, {2.5, black}, {-0.5, white}, {-1.5, black}} {
+		if got := proceduralTexture(stripes, newVec3(tc.x, 3, -4)); got != tc.want {
+			t.Errorf("Unexpected stripes color %v at x = %g, expected %v.", got, tc.x, tc.want)
+		}
+	}
+
+	gradient := newProceduralTexture(GradientTexture, black, white, newVec3(0, 1, 0), 0.25, 1, TextureSpaceObject, NoisePerlin, 0)
+	for _, tc := range []struct {
+		y, want float
+	}{{-1, 0}, {0, 0}, {1, 0.25}, {2, 0.5}, {4, 1}, {9, 1}} {
+		if got := proceduralTexture(gradient, newVec3(5, tc.y, 5)); math.Abs(got.x-tc.want) > 1e-9 {
+			t.Errorf("Unexpected gradient %g at y = %g, expected %g.", got.x, tc.y, tc.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The shader files can't have tests, k_*.go being embedded as the shader source.
+
+// mirrorScene returns a reflective floor, half reflecting a diffuse sphere, lit by the ambient light only.
+func mirrorScene() (ThingsT, BVHT, MaterialsT, mat4) {
+	things, bvh := buildBVH(ThingsT{
+		newPlane(newVec3(0, 0, 0), newVec3(0, 1, 0), 0),
+		newSphere(newVec3(0, 2, -4), 0.5, 1),
+	})
+	materials := MaterialsT{
+		newMaterial(newVec4(0.2, 0.2, 0.2, 1), 0.5, 0, 0, 0, 0.5, 0, 1, -1, 0, 0, 0, -1),
+		newMaterial(newVec4(1, 0, 0, 1), 0.8, 0, 0, 0, 0, 0, 1, -1, 0, 0, 0, -1),
+	}
+	return things, bvh, materials, newLight(PointLightType, newVec3(0, 0, 0), newVec3(0, 0, 0), newVec4(1, 1, 1, 1), 1, 0, 0)
+}
+
+func TestTraceBounces(t *testing.T) {
+	t.Parallel()
+
+	things, bvh, materials, ambientLight := mirrorScene()
+	start, dir := newVec3(0, 2, 0), normalize3(newVec3(0, -1, -1))
+
+	// The floor, then the sphere in the reflection.
+	floor, hitPoint, hitNormal, _, matIdx, hit := shade(start, dir, nil, things, bvh, materials, TexturesT{}, ambientLight, 0)
+	if !hit || matIdx != 0 {
+		t.Fatalf("Unexpected first hit: %t, material %d, expected the floor.", hit, matIdx)
+	}
+	sphere, _, _, _, _, hit := shade(hitPoint, reflect3(dir, hitNormal), nil, things, bvh, materials, TexturesT{}, ambientLight, 0)
+	if !hit || sphere.x == 0 {
+		t.Fatalf("Unexpected reflection %v (hit: %t), expected the red sphere.", sphere, hit)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		depth int
+		want  vec4
+	}{
+		{"no bounce", 0, add4(newVec4(0, 0, 0, 1), floor)},
+		{"one bounce", 1, add4(add4(newVec4(0, 0, 0, 1), floor), scale4(sphere, 0.5))},
+		// The sphere isn't reflective, the next bounces don't contribute.
+		{"max depth", maxDepth, add4(add4(newVec4(0, 0, 0, 1), floor), scale4(sphere, 0.5))},
+	} {
+		if got := trace(start, dir, nil, things, bvh, materials, TexturesT{}, ambientLight, tc.depth, 0); got != tc.want {
+			t.Errorf("%s: unexpected color %v, expected %v.", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTraceMinContribution(t *testing.T) {
+	t.Parallel()
+
+	// Two facing mirrors reflecting 10% of the light, the ray bounces between them forever.
+	things, bvh := buildBVH(ThingsT{
+		newPlane(newVec3(0, 0, 0), newVec3(0, 0, 1), 0),
+		newPlane(newVec3(0, 0, -2), newVec3(0, 0, -1), 0),
+	})
+	materials := MaterialsT{newMaterial(newVec4(1, 1, 1, 1), 0.5, 0, 0, 0, 0.1, 0, 1, -1, 0, 0, 0, -1)}
+	ambientLight := newLight(PointLightType, newVec3(0, 0, 0), newVec3(0, 0, 0), newVec4(1, 1, 1, 1), 1, 0, 0)
+	start, dir := newVec3(0, 0, -1), newVec3(0, 0, 1)
+
+	// After 3 hits, the weight is 0.1^3, under minContribution, the bounces stop there.
+	full := trace(start, dir, nil, things, bvh, materials, TexturesT{}, ambientLight, maxDepth, 0)
+	if got := trace(start, dir, nil, things, bvh, materials, TexturesT{}, ambientLight, 2, 0); got != full {
+		t.Errorf("Unexpected color %v at depth 2, expected %v as at max depth.", got, full)
+	}
+	if got := trace(start, dir, nil, things, bvh, materials, TexturesT{}, ambientLight, 1, 0); got == full {
+		t.Errorf("Unexpected color %v at depth 1, expected the third hit to contribute.", got)
+	}
+}
+
+func TestTraceMiss(t *testing.T) {
+	t.Parallel()
+
+	things, bvh, materials, ambientLight := mirrorScene()
+	want := add4(newVec4(0, 0, 0, 1), newVec4(0.1, 0.1, 0.1, 1)) // The background, over the initial opaque black.
+	if got := trace(newVec3(0, 2, 0), newVec3(0, 1, 0), nil, things, bvh, materials, TexturesT{}, ambientLight, maxDepth, 0); got != want {
+		t.Errorf("Unexpected color %v, expected the background %v.", got, want)
+	}
+}
+
+// TestSchlick checks the Fresnel reflectance at normal incidence, grazing angle and past the critical angle.
+func TestSchlick(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		cosI, eta float
+		want      float
+	}{
+		{"normal incidence into glass", 1, 1 / 1.5, 0.04},
+		{"normal incidence out of glass", 1, 1.5, 0.04},
+		{"grazing", 0, 1 / 1.5, 1},
+		{"same medium", 1, 1, 0},
+		{"total internal reflection", 0.5, 1.5, 1},
+	} {
+		if got := schlick(tc.cosI, tc.eta); abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: unexpected reflectance %g, expected %g.", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestRefractTotalInternalReflection checks refract3 bends the ray toward the normal entering a denser medium
+// and returns a zero vector past the critical angle.
+func TestRefractTotalInternalReflection(t *testing.T) {
+	t.Parallel()
+
+	normal := newVec3(0, 1, 0)
+	in := normalize3(newVec3(1, -1, 0)) // 45°.
+
+	into := refract3(in, normal, 1/1.5)
+	if into.y >= 0 || abs(into.x-math.Sqrt(0.5)/1.5) > 1e-9 {
+		t.Errorf("Unexpected refracted ray %v entering glass.", into)
+	}
+	// Leaving glass at 45° is past the critical angle, ~41.8°.
+	if got := refract3(in, normal, 1.5); got != newVec3(0, 0, 0) {
+		t.Errorf("Unexpected refracted ray %v, expected total internal reflection.", got)
+	}
+}
+
+// TestShadeBackSide checks the back side of an opaque plane isn't lit, as before the transparent materials,
+// while the returned normal faces the ray for the refraction.
+func TestShadeBackSide(t *testing.T) {
+	t.Parallel()
+
+	// Plane facing down, seen and lit from above.
+	things, bvh := buildBVH(ThingsT{newPlane(newVec3(0, 0, 0), newVec3(0, -1, 0), 0)})
+	materials := MaterialsT{newMaterial(newVec4(1, 1, 1, 1), 0.5, 1, 0, 0, 0, 0, 1, -1, 0, 0, 0, -1)}
+	lights := LightsT{newLight(PointLightType, newVec3(0, 5, 0), newVec3(0, 0, 0), newVec4(1, 1, 1, 1), 1, 0, 0)}
+	ambientLight := newLight(PointLightType, newVec3(0, 0, 0), newVec3(0, 0, 0), newVec4(1, 1, 1, 1), 1, 0, 0)
+
+	got, _, hitNormal, inside, _, hit := shade(newVec3(0, 2, 0), newVec3(0, -1, 0), lights, things, bvh, materials, TexturesT{}, ambientLight, 0)
+	if !hit || !inside || hitNormal != newVec3(0, 1, 0) {
+		t.Fatalf("Unexpected hit: %t, inside %t, normal %v, expected the back side with the normal facing the ray.", hit, inside, hitNormal)
+	}
+	if want := newVec4(0.5, 0.5, 0.5, 1); got != want {
+		t.Errorf("Unexpected color %v, expected the ambient light only %v.", got, want)
+	}
+}
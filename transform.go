@@ -0,0 +1,205 @@
+package main
+
+// This file holds the object transforms, set in the scene with the transform block of the objects:
+//
+//	{"type": "sphere", ..., "transform": {"scale": [2, 1, 1], "rotate": [0, 0, 45], "translate": [1, 0, 0]}}
+//
+// The object is scaled, then rotated, then translated, around the origin.
+
+// affine is a 3x4 matrix, the last column being the translation.
+type affine [3][4]float
+
+// mul returns a*b, applying b first.
+func (a affine) mul(b affine) affine {
+	var out affine
+	for i := range 3 {
+		for j := range 4 {
+			for k := range 3 {
+				out[i][j] += a[i][k] * b[k][j]
+			}
+		}
+		out[i][3] += a[i][3]
+	}
+	return out
+}
+
+func (a affine) point(p vec3) vec3 {
+	return newVec3(
+		a[0][0]*p.x+a[0][1]*p.y+a[0][2]*p.z+a[0][3],
+		a[1][0]*p.x+a[1][1]*p.y+a[1][2]*p.z+a[1][3],
+		a[2][0]*p.x+a[2][1]*p.y+a[2][2]*p.z+a[2][3],
+	)
+}
+
+// inverse returns the inverse matrix. The transforms are validated to be invertible.
+func (a affine) inverse() affine {
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+
+	var out affine
+	out[0][0] = (a[1][1]*a[2][2] - a[1][2]*a[2][1]) / det
+	out[0][1] = (a[0][2]*a[2][1] - a[0][1]*a[2][2]) / det
+	out[0][2] = (a[0][1]*a[1][2] - a[0][2]*a[1][1]) / det
+	out[1][0] = (a[1][2]*a[2][0] - a[1][0]*a[2][2]) / det
+	out[1][1] = (a[0][0]*a[2][2] - a[0][2]*a[2][0]) / det
+	out[1][2] = (a[0][2]*a[1][0] - a[0][0]*a[1][2]) / det
+	out[2][0] = (a[1][0]*a[2][1] - a[1][1]*a[2][0]) / det
+	out[2][1] = (a[0][1]*a[2][0] - a[0][0]*a[2][1]) / det
+	out[2][2] = (a[0][0]*a[1][1] - a[0][1]*a[1][0]) / det
+
+	// The inverse translation is the opposite translation, rotated and scaled back.
+	for i := range 3 {
+		out[i][3] = -(out[i][0]*a[0][3] + out[i][1]*a[1][3] + out[i][2]*a[2][3])
+	}
+	return out
+}
+
+// mat4 encodes the matrix as a transform thing, the matrix being the inverse of the object transform.
+func (a affine) mat4() mat4 {
+	return newTransform(
+		newVec4(a[0][0], a[0][1], a[0][2], a[0][3]),
+		newVec4(a[1][0], a[1][1], a[1][2], a[1][3]),
+		newVec4(a[2][0], a[2][1], a[2][2], a[2][3]),
+	)
+}
+
+// getAffine decodes the matrix of the given transform thing.
+func getAffine(transform mat4) affine {
+	var out affine
+	for i := range 3 {
+		row := transform[i+1]
+		out[i] = [4]float{row.x, row.y, row.z, row.w}
+	}
+	return out
+}
+
+func scaleAffine(s vec3) affine {
+	return affine{{s.x, 0, 0, 0}, {0, s.y, 0, 0}, {0, 0, s.z, 0}}
+}
+
+func translateAffine(t vec3) affine {
+	return affine{{1, 0, 0, t.x}, {0, 1, 0, t.y}, {0, 0, 1, t.z}}
+}
+
+// rotateAffine returns the rotation of the given angle, in radians, around the given axis (Rodrigues' formula).
+func rotateAffine(axis vec3, angle float) affine {
+	u := normalize3(axis)
+	c, s := cos(angle), sin(angle)
+	t := 1 - c
+	return affine{
+		{t*u.x*u.x + c, t*u.x*u.y - s*u.z, t*u.x*u.z + s*u.y, 0},
+		{t*u.x*u.y + s*u.z, t*u.y*u.y + c, t*u.y*u.z - s*u.x, 0},
+		{t*u.x*u.z - s*u.y, t*u.y*u.z + s*u.x, t*u.z*u.z + c, 0},
+	}
+}
+
+// eulerAffine returns the rotation of the given Euler angles, in degrees, around x, then y, then z.
+func eulerAffine(angles vec3) affine {
+	return rotateAffine(newVec3(0, 0, 1), angles.z*pi/180).
+		mul(rotateAffine(newVec3(0, 1, 0), angles.y*pi/180)).
+		mul(rotateAffine(newVec3(1, 0, 0), angles.x*pi/180))
+}
+
+type transform struct {
+	Translate vec3  `json:"translate"`
+	Rotate    vec3  `json:"rotate"` // Euler angles in degrees, around x, then y, then z.
+	Axis      vec3  `json:"axis"`   // Rotation axis, with angle, instead of rotate.
+	Angle     float `json:"angle"`  // In degrees.
+	Scale     vec3  `json:"scale"`
+}
+
+func (t *transform) validate(v *sceneValidator, path string) {
+	if !v.has(joinPath(path, "scale")) {
+		t.Scale = newVec3(1, 1, 1)
+	}
+	if v.ok(joinPath(path, "scale")) && t.Scale.x*t.Scale.y*t.Scale.z == 0 {
+		v.errorf(joinPath(path, "scale"), "must not have a zero component")
+	}
+	if v.has(joinPath(path, "axis")) || v.has(joinPath(path, "angle")) {
+		v.require(path, "axis", "angle")
+		if v.has(joinPath(path, "rotate")) {
+			v.errorf(joinPath(path, "rotate"), "can't be used with axis and angle")
+		}
+		if v.ok(joinPath(path, "axis")) && length3(t.Axis) == 0 {
+			v.errorf(joinPath(path, "axis"), "must not be a zero vector")
+		}
+	}
+}
+
+// matrix returns the object to world matrix.
+func (t transform) matrix() affine {
+	var rotation affine
+	if length3(t.Axis) != 0 {
+		rotation = rotateAffine(t.Axis, t.Angle*pi/180)
+	} else {
+		rotation = eulerAffine(t.Rotate)
+	}
+	return translateAffine(t.Translate).mul(rotation).mul(scaleAffine(t.Scale))
+}
+
+// transformedObject is a scene object with a transform.
+type transformedObject struct {
+	sceneObject
+	matrix affine
+}
+
+// things returns the things of the object followed by their transform.
+// The triangles are transformed directly, there can be many of them and they already have a following slot for the normals.
+func (o transformedObject) things() ThingsT {
+	inverse := o.matrix.inverse()
+	things := o.sceneObject.things()
+	out := make(ThingsT, 0, len(things)*2)
+	for i := 0; i < len(things); i++ {
+		elem := things[i]
+		switch getThingType(elem) {
+		case TriangleType:
+			v0, v1, v2, hasNormals := getTriangle(elem)
+			out = append(out, newTriangle(o.matrix.point(v0), o.matrix.point(v1), o.matrix.point(v2), hasNormals, getThingMaterialIdx(elem)))
+		case TriangleNormalsType:
+			m := inverse.mat4()
+			out = append(out, newTriangleNormals(transformNormal(m, elem[1].xyz), transformNormal(m, elem[2].xyz), transformNormal(m, elem[3].xyz)))
+		case CSGType:
+			// The children get the transform, so they all end up followed by one.
+			operation, _, slots := getCSG(elem)
+			children := 0
+			for _, child := range things[i+1 : i+1+slots] {
+				if getThingType(child) != TransformType {
+					children++
+				}
+			}
+			out = append(out, newCSG(operation, 0, children*2))
+		default:
+			// The children of the CSG can have their own transform, applied first.
+			if transform, ok := getThingTransform(things, i); ok {
+				out = append(out, elem, getAffine(transform).mul(inverse).mat4())
+				i++
+				continue
+			}
+			out = append(out, elem, inverse.mat4())
+		}
+	}
+
+	return out
+}
+
+// transformBounds returns the world bounding box of the given object space box.
+func transformBounds(transform mat4, lo, hi vec3) (vec3, vec3) {
+	m := getAffine(transform).inverse()
+	outLo, outHi := m.point(lo), m.point(lo)
+	for i := range 8 {
+		x, y, z := lo.x, lo.y, lo.z
+		if i&1 != 0 {
+			x = hi.x
+		}
+		if i&2 != 0 {
+			y = hi.y
+		}
+		if i&4 != 0 {
+			z = hi.z
+		}
+		p := m.point(newVec3(x, y, z))
+		outLo, outHi = minVec3(outLo, p), maxVec3(outHi, p)
+	}
+	return outLo, outHi
+}
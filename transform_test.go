@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestAffineInverse checks the inverse cancels the transform, including the translation.
+func TestAffineInverse(t *testing.T) {
+	t.Parallel()
+
+	for _, tr := range []transform{
+		{Scale: newVec3(1, 1, 1)},
+		{Translate: newVec3(1, 2, 3), Rotate: newVec3(30, 45, 60), Scale: newVec3(2, 0.5, 3)},
+		{Translate: newVec3(-4, 0, 1), Axis: newVec3(1, 1, 0), Angle: 120, Scale: newVec3(-1, 1, 0.1)},
+	} {
+		m := tr.matrix()
+		inv := m.inverse()
+		for _, p := range []vec3{newVec3(0, 0, 0), newVec3(1, -2, 3), newVec3(100, 5, -7)} {
+			if got := inv.point(m.point(p)); !approxVec3(got, p, 1e-9) {
+				t.Errorf("Unexpected round trip of %v through %+v: %v.", p, tr, got)
+			}
+		}
+		identity := m.mul(inv)
+		for i := range 3 {
+			for j := range 4 {
+				want := 0.0
+				if i == j {
+					want = 1
+				}
+				if math.Abs(identity[i][j]-want) > 1e-9 {
+					t.Fatalf("Unexpected m*inverse for %+v: %v.", tr, identity)
+				}
+			}
+		}
+	}
+}
+
+// TestTransformNormal checks the normals stay perpendicular to the surface under a non uniform scale.
+func TestTransformNormal(t *testing.T) {
+	t.Parallel()
+
+	m := transform{Rotate: newVec3(0, 0, 30), Scale: newVec3(2, 1, 1)}.matrix()
+	inverse := m.inverse().mat4()
+
+	// A surface going along (1, -1, 0), with the (1, 1, 0) normal in the object space.
+	tangent := sub3(m.point(newVec3(1, -1, 0)), m.point(newVec3(0, 0, 0)))
+	n := transformNormal(inverse, normalize3(newVec3(1, 1, 0)))
+	if math.Abs(length3(n)-1) > 1e-9 {
+		t.Errorf("Unexpected normal %v, not normalized.", n)
+	}
+	if d := dot3(n, tangent); math.Abs(d) > 1e-9 {
+		t.Errorf("Unexpected normal %v, not perpendicular to the surface %v: %g.", n, tangent, d)
+	}
+}
+
+// TestTransformedSphere checks the rays hit the transformed sphere in the world space, with the world normal.
+func TestTransformedSphere(t *testing.T) {
+	t.Parallel()
+
+	// Unit sphere stretched along x, moved to x = 5.
+	m := transform{Translate: newVec3(5, 0, 0), Scale: newVec3(2, 1, 1)}.matrix()
+	inverse := m.inverse().mat4()
+	things := ThingsT{newSphere(newVec3(0, 0, 0), 1, 0), inverse}
+
+	if dist, _ := intersectThing(newVec3(10, 0, 0), newVec3(-1, 0, 0), things, 0, 0.001, -1); math.Abs(dist-3) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected 3 along x.", dist)
+	}
+	if dist, _ := intersectThing(newVec3(5, 10, 0), newVec3(0, -1, 0), things, 0, 0.001, -1); math.Abs(dist-9) > 1e-9 {
+		t.Errorf("Unexpected hit at %g, expected 9 along y.", dist)
+	}
+
+	lo, hi := transformBounds(inverse, newVec3(-1, -1, -1), newVec3(1, 1, 1))
+	if !approxVec3(lo, newVec3(3, -1, -1), 1e-9) || !approxVec3(hi, newVec3(7, 1, 1), 1e-9) {
+		t.Errorf("Unexpected bounds %v %v.", lo, hi)
+	}
+}